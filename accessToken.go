@@ -4,11 +4,13 @@ import (
     "bytes"
     "encoding/json"
     "io"
-    "log"
     "net/http"
+    "sync"
+    "time"
 )
 
 var (
+    // AccessTokenUrl 获取企业内部应用accessToken的接口地址，测试时可以替换
     AccessTokenUrl = "https://api.dingtalk.com/v1.0/oauth2/accessToken"
 )
 
@@ -30,7 +32,7 @@ type AccessToken struct {
 
 // getAccessTokenFromDing 从钉钉获取企业内部应用的accessToken
 // 参考： https://open.dingtalk.com/document/orgapp-server/obtain-the-access_token-of-an-internal-app
-func getAccessTokenFromDing(aks AppKeySecret) (*AccessToken, error) {
+func getAccessTokenFromDing(hc *http.Client, url string, aks AppKeySecret) (*AccessToken, error) {
     ks := AppKeySecret{
         AppKey:    aks.AppKey,
         AppSecret: aks.AppSecret,
@@ -39,7 +41,7 @@ func getAccessTokenFromDing(aks AppKeySecret) (*AccessToken, error) {
     if err != nil {
         return nil, err
     }
-    resp, err := http.Post(AccessTokenUrl, ContentTypeJson, bytes.NewBuffer(ksByte))
+    resp, err := hc.Post(url, ContentTypeJson, bytes.NewBuffer(ksByte))
     if err != nil {
         return nil, err
     }
@@ -51,6 +53,10 @@ func getAccessTokenFromDing(aks AppKeySecret) (*AccessToken, error) {
         return nil, err
     }
 
+    if err = parseDingResp(resp.StatusCode, datByte); err != nil {
+        return nil, err
+    }
+
     var dat AccessToken
 
     err = json.Unmarshal(datByte, &dat)
@@ -61,24 +67,108 @@ func getAccessTokenFromDing(aks AppKeySecret) (*AccessToken, error) {
     return &dat, nil
 }
 
-// GetAccessToken 获取access token 先从cache，否则在从钉钉
-func GetAccessToken(aks AppKeySecret) (string, error) {
-    datKey := "DingAccessToken"
-    tokenByte, err := cache.Get(datKey)
+// TokenProvider 提供企业内部应用的accessToken
+type TokenProvider interface {
+    AccessToken(aks AppKeySecret) (string, error)
+}
+
+// CacheTokenProvider 先从cache获取accessToken，否则再从钉钉获取。每个 CacheTokenProvider 有自己的cache，
+// cache只是一个加锁的map，没有后台goroutine，可以为每个客户端创建，不用时调用 Close 释放缓存的accessToken
+type CacheTokenProvider struct {
+    // 获取accessToken的地址，为空时使用 AccessTokenUrl
+    Url string
+    // 请求钉钉使用的 http.Client，为nil时使用 http.DefaultClient
+    HTTPClient *http.Client
+    // 从钉钉获取accessToken后调用，用于指标和日志
+    OnRefresh func(appKey string, latency time.Duration, err error)
+
+    mu    sync.Mutex
+    cache map[string]cachedToken
+}
+
+// cachedToken 缓存的accessToken和过期时间
+type cachedToken struct {
+    token   string
+    expires time.Time
+}
+
+var (
+    // DefaultTokenProvider 默认的TokenProvider，没有单独设置的客户端都使用它
+    DefaultTokenProvider = &CacheTokenProvider{}
+
+    // 钉钉默认的accessToken 有效期为7200秒（2小时），没有返回有效期时使用
+    defaultTokenTTL = 7200 * time.Second
+    // 提前过期的时间，避免使用快要过期的accessToken
+    tokenExpiryMargin = 200 * time.Second
+)
+
+// AccessToken 获取access token 先从cache，否则在从钉钉
+func (p *CacheTokenProvider) AccessToken(aks AppKeySecret) (string, error) {
+    // 不同的应用有不同的accessToken
+    p.mu.Lock()
+    ct, ok := p.cache[aks.AppKey]
+    p.mu.Unlock()
+    if ok && time.Now().Before(ct.expires) {
+        return ct.token, nil
+    }
+
+    // 缓存里没有或者已经过期，就请求钉钉获取
+    url := p.Url
+    if url == "" {
+        url = AccessTokenUrl
+    }
+    hc := p.HTTPClient
+    if hc == nil {
+        hc = http.DefaultClient
+    }
+    start := time.Now()
+    at, err := getAccessTokenFromDing(hc, url, aks)
+    latency := time.Since(start)
+    if m := DefaultMetrics; m != nil {
+        m.ObserveTokenRefresh(aks.AppKey, latency, err)
+    }
+    if p.OnRefresh != nil {
+        p.OnRefresh(aks.AppKey, latency, err)
+    }
+    // 从钉钉也没获取到就没办法，返回错误了
     if err != nil {
-        // 缓存里没有，就请求钉钉获取
-        at, err := getAccessTokenFromDing(aks)
-        // 从钉钉也没获取到就没办法，返回错误了
-        if err != nil {
-            return "", err
-        }
-        err = cache.Set(datKey, []byte(at.Token))
-        if err != nil {
-            // 写入cache失败，并不是大问题，大不了再从钉钉获取，先让调用者能用再说
-            log.Println("set ding access token to cache failed: " + err.Error())
-        }
-        return at.Token, nil
+        return "", err
+    }
+    ttl := defaultTokenTTL
+    if at.ExpireIn > 0 {
+        ttl = time.Duration(at.ExpireIn) * time.Second
+    }
+    if ttl > 2*tokenExpiryMargin {
+        ttl -= tokenExpiryMargin
+    }
+    p.mu.Lock()
+    if p.cache == nil {
+        p.cache = map[string]cachedToken{}
     }
+    p.cache[aks.AppKey] = cachedToken{token: at.Token, expires: start.Add(ttl)}
+    p.mu.Unlock()
+    return at.Token, nil
+}
+
+// Reset 清空缓存的accessToken
+func (p *CacheTokenProvider) Reset() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.cache = nil
+    return nil
+}
+
+// Close 释放缓存的accessToken，之后仍然可以使用，会重新从钉钉获取
+func (p *CacheTokenProvider) Close() error {
+    return p.Reset()
+}
+
+// GetAccessToken 获取access token 先从cache，否则在从钉钉，使用 DefaultTokenProvider
+func GetAccessToken(aks AppKeySecret) (string, error) {
+    return DefaultTokenProvider.AccessToken(aks)
+}
 
-    return string(tokenByte), nil
+// ResetTokenCache 清空 DefaultTokenProvider 缓存的accessToken，用于测试或应用的appSecret变更后
+func ResetTokenCache() error {
+    return DefaultTokenProvider.Reset()
 }
@@ -0,0 +1,111 @@
+package ding_test
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+func TestCacheTokenProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		expireIn int64
+		sends    int
+		wait     time.Duration
+		want     int
+	}{
+		{name: "cached", sends: 3, want: 1},
+		{name: "refreshed after expiry", expireIn: 1, sends: 2, wait: 1100 * time.Millisecond, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dingtest.NewServer()
+			defer srv.Close()
+			srv.AddApp("appKey", "appSecret")
+			srv.TokenExpireIn = tt.expireIn
+
+			var mu sync.Mutex
+			refreshes := 0
+			p := &ding.CacheTokenProvider{Url: srv.AccessTokenUrl(), OnRefresh: func(appKey string, latency time.Duration, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					t.Errorf("refresh %s: %v", appKey, err)
+				}
+				refreshes++
+			}}
+			defer p.Close()
+			c, err := srv.NewGroupClient("robot", "appKey", "appSecret", ding.WithTokenProvider(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.sends; i++ {
+				if i == tt.sends-1 {
+					time.Sleep(tt.wait)
+				}
+				if err = c.SendTextMsg("hello", "cid1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if refreshes != tt.want {
+				t.Errorf("fetched %d tokens, want %d", refreshes, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheTokenProviderReset(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddApp("appKey", "appSecret")
+	c, err := srv.NewGroupClient("robot", "appKey", "appSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SendTextMsg("hello", "cid1"); err != nil {
+		t.Fatal(err)
+	}
+	srv.RevokeTokens()
+	if err = c.SendTextMsg("hello", "cid1"); err == nil {
+		t.Fatal("send with a revoked token succeeded")
+	}
+	p, ok := c.TokenProvider.(*ding.CacheTokenProvider)
+	if !ok {
+		t.Fatalf("TokenProvider = %T, want *ding.CacheTokenProvider", c.TokenProvider)
+	}
+	if err = p.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SendTextMsg("hello", "cid1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManyClientsWithOwnTokenCache(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddApp("appKey", "appSecret")
+	hc := &http.Client{Timeout: time.Second}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		c, err := srv.NewGroupClient("robot", "appKey", "appSecret", ding.WithHTTPClient(hc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 0 {
+			if err = c.SendTextMsg("hello", "cid1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	hc.CloseIdleConnections()
+	if after := runtime.NumGoroutine(); after > before+10 {
+		t.Errorf("goroutines grew from %d to %d after creating 1000 clients", before, after)
+	}
+}
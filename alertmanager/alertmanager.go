@@ -0,0 +1,120 @@
+// Package alertmanager 接收 Prometheus Alertmanager 的 webhook 通知，渲染成钉钉markdown消息后转发到钉钉，
+// 可以替代单独部署的 prometheus-webhook-dingtalk。
+// 参考： https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+
+package alertmanager
+
+import (
+	"sort"
+	"time"
+)
+
+var (
+	// StatusFiring 告警触发中
+	StatusFiring = "firing"
+	// StatusResolved 告警已恢复
+	StatusResolved = "resolved"
+)
+
+// Message Alertmanager webhook 发来的post body
+type Message struct {
+	// 消息格式版本，目前为4
+	Version string `json:"version"`
+	// 告警分组的key，用于区分不同的告警分组
+	GroupKey string `json:"groupKey"`
+	// 由于 max_alerts 被截断的告警数量
+	TruncatedAlerts int `json:"truncatedAlerts"`
+	// firing 或 resolved，只要有一条告警在触发中就是 firing
+	Status string `json:"status"`
+	// Alertmanager 中的 receiver 名字
+	Receiver string `json:"receiver"`
+	// 分组的标签
+	GroupLabels KV `json:"groupLabels"`
+	// 所有告警共有的标签
+	CommonLabels KV `json:"commonLabels"`
+	// 所有告警共有的注解
+	CommonAnnotations KV `json:"commonAnnotations"`
+	// Alertmanager 的外部访问地址
+	ExternalURL string `json:"externalURL"`
+	// 告警列表
+	Alerts Alerts `json:"alerts"`
+}
+
+// Alert 单条告警
+type Alert struct {
+	// firing 或 resolved
+	Status string `json:"status"`
+	// 告警的标签
+	Labels KV `json:"labels"`
+	// 告警的注解，一般有 summary、description
+	Annotations KV `json:"annotations"`
+	// 告警开始时间
+	StartsAt time.Time `json:"startsAt"`
+	// 告警结束时间，触发中的告警为零值
+	EndsAt time.Time `json:"endsAt"`
+	// 产生告警的 Prometheus 表达式地址
+	GeneratorURL string `json:"generatorURL"`
+	// 告警的指纹
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Alerts 告警列表
+type Alerts []Alert
+
+// Firing 触发中的告警
+func (as Alerts) Firing() Alerts {
+	return as.filter(StatusFiring)
+}
+
+// Resolved 已恢复的告警
+func (as Alerts) Resolved() Alerts {
+	return as.filter(StatusResolved)
+}
+
+func (as Alerts) filter(status string) Alerts {
+	res := Alerts{}
+	for _, a := range as {
+		if a.Status == status {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// KV 标签或注解
+type KV map[string]string
+
+// Pair 一个键值对
+type Pair struct {
+	Name  string
+	Value string
+}
+
+// SortedPairs 按key排序后的键值对，方便在模板中稳定输出
+func (kv KV) SortedPairs() []Pair {
+	names := make([]string, 0, len(kv))
+	for k := range kv {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	pairs := make([]Pair, len(names))
+	for i, k := range names {
+		pairs[i] = Pair{Name: k, Value: kv[k]}
+	}
+	return pairs
+}
+
+// Remove 去掉指定的key后返回新的KV，常用于在模板里去掉 alertname 之类已经展示过的标签
+func (kv KV) Remove(keys []string) KV {
+	res := KV{}
+	skip := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		skip[k] = true
+	}
+	for k, v := range kv {
+		if !skip[k] {
+			res[k] = v
+		}
+	}
+	return res
+}
@@ -0,0 +1,151 @@
+package alertmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/wanghkkk/ding"
+)
+
+var (
+	// MaxBodySize Alertmanager webhook 请求体的最大字节数
+	MaxBodySize int64 = 4 << 20
+)
+
+// Mention 根据告警标签决定@谁，匹配触发中的告警
+type Mention struct {
+	// 标签名
+	Label string `json:"label"`
+	// 标签值，为空表示只要告警有这个标签就匹配
+	Value string `json:"value,omitempty"`
+	// 匹配时@这些用户userid
+	UserIds []string `json:"userIds,omitempty"`
+	// 匹配时@这些手机号
+	Mobiles []string `json:"mobiles,omitempty"`
+	// 匹配时@所有人
+	AtAll bool `json:"atAll,omitempty"`
+}
+
+func (m *Mention) match(a Alert) bool {
+	v, ok := a.Labels[m.Label]
+	if !ok {
+		return false
+	}
+	return m.Value == "" || m.Value == v
+}
+
+// Forwarder 把 Alertmanager 的通知转发到钉钉，同时也是一个 http.Handler，
+// 在 Alertmanager 的 webhook_configs 中配置它的地址即可
+type Forwarder struct {
+	// 发送者，可以用 WebhookSender 或 GroupSender 创建
+	Sender Sender
+	// 消息模板，为nil时使用默认模板
+	Template *Template
+	// 根据标签@人的规则
+	Mentions []Mention
+	// 为true时，全部告警都已恢复的通知不再发送
+	SkipResolved bool
+}
+
+// NewForwarder 创建转发器，使用默认模板
+func NewForwarder(sender Sender) *Forwarder {
+	return &Forwarder{Sender: sender}
+}
+
+// NewWebhookForwarder 创建通过webhook方式发送的转发器
+func NewWebhookForwarder(c *ding.WhClient) *Forwarder {
+	return NewForwarder(WebhookSender(c))
+}
+
+// NewGroupForwarder 创建通过接口方式发送到openConversationId群的转发器
+func NewGroupForwarder(c *ding.GroupClient, openConversationId string) *Forwarder {
+	return NewForwarder(GroupSender(c, openConversationId))
+}
+
+// Forward 渲染并发送一条 Alertmanager 通知
+func (f *Forwarder) Forward(msg *Message) error {
+	if len(msg.Alerts) == 0 {
+		return nil
+	}
+	if f.SkipResolved && len(msg.Alerts.Firing()) == 0 {
+		return nil
+	}
+	tmpl := f.Template
+	if tmpl == nil {
+		var err error
+		tmpl, err = DefaultTemplate()
+		if err != nil {
+			return err
+		}
+	}
+	title, text, err := tmpl.Render(msg)
+	if err != nil {
+		return fmt.Errorf("render alertmanager template failed: %w", err)
+	}
+
+	at := f.mentions(msg.Alerts.Firing())
+	// 钉钉要求正文中带上 @userId 或 @手机号 才有@效果
+	var a []string
+	for _, id := range at.AtUserIds {
+		a = append(a, "@"+id)
+	}
+	for _, m := range at.AtMobiles {
+		a = append(a, "@"+m)
+	}
+	if len(a) > 0 {
+		text = fmt.Sprintf("%s\n\n%s", text, strings.Join(a, " "))
+	}
+	return f.Sender.SendMarkdown(title, text, at)
+}
+
+// mentions 汇总所有匹配的@规则，去重
+func (f *Forwarder) mentions(alerts Alerts) ding.At {
+	var at ding.At
+	seen := map[string]bool{}
+	for i := range f.Mentions {
+		m := &f.Mentions[i]
+		for _, a := range alerts {
+			if !m.match(a) {
+				continue
+			}
+			at.IsAtAll = at.IsAtAll || m.AtAll
+			for _, id := range m.UserIds {
+				if !seen["u:"+id] {
+					seen["u:"+id] = true
+					at.AtUserIds = append(at.AtUserIds, id)
+				}
+			}
+			for _, mobile := range m.Mobiles {
+				if !seen["m:"+mobile] {
+					seen["m:"+mobile] = true
+					at.AtMobiles = append(at.AtMobiles, mobile)
+				}
+			}
+			break
+		}
+	}
+	return at
+}
+
+// ServeHTTP 接收 Alertmanager 的 webhook 请求。
+// 请求体不合法返回400，发送钉钉失败返回502，Alertmanager 会在失败时重试
+func (f *Forwarder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var msg Message
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize)).Decode(&msg); err != nil {
+		http.Error(w, "invalid alertmanager message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := f.Forward(&msg); err != nil {
+		log.Printf("forward alertmanager message %q to ding failed: %s\n", msg.GroupKey, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
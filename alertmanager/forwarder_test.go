@@ -0,0 +1,194 @@
+package alertmanager_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/alertmanager"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+const (
+	firingBody = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"HighErrorRate\"}",
+  "status": "firing",
+  "receiver": "ding",
+  "commonLabels": {"alertname": "HighErrorRate", "severity": "critical"},
+  "externalURL": "http://alertmanager:9093",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighErrorRate", "severity": "critical", "service": "order"},
+      "annotations": {"summary": "order error rate is 12%"},
+      "startsAt": "2024-05-01T10:00:00Z",
+      "generatorURL": "http://prometheus:9090/graph"
+    }
+  ]
+}`
+	resolvedBody = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"HighErrorRate\"}",
+  "status": "resolved",
+  "receiver": "ding",
+  "commonLabels": {"alertname": "HighErrorRate", "severity": "critical"},
+  "alerts": [
+    {
+      "status": "resolved",
+      "labels": {"alertname": "HighErrorRate", "severity": "critical"},
+      "annotations": {"summary": "order error rate is 12%"},
+      "startsAt": "2024-05-01T10:00:00Z",
+      "endsAt": "2024-05-01T10:05:00Z"
+    }
+  ]
+}`
+)
+
+func TestForwardThroughDingtest(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		skipResolved bool
+		mentions     []alertmanager.Mention
+		fault        *dingtest.Fault
+		wantStatus   int
+		wantTitle    string
+		wantText     []string
+		wantAt       ding.At
+		wantNoSend   bool
+	}{
+		{
+			name:       "firing",
+			body:       firingBody,
+			wantStatus: http.StatusOK,
+			wantTitle:  "[FIRING:1] HighErrorRate",
+			wantText:   []string{"触发中 (1)", "order error rate is 12%", "service: order", "[Alertmanager](http://alertmanager:9093)"},
+		},
+		{
+			name:       "firing with mentions",
+			body:       firingBody,
+			mentions:   []alertmanager.Mention{{Label: "severity", Value: "critical", UserIds: []string{"u1"}, Mobiles: []string{"13800000000"}}, {Label: "team", UserIds: []string{"u2"}}},
+			wantStatus: http.StatusOK,
+			wantTitle:  "[FIRING:1] HighErrorRate",
+			wantText:   []string{"@u1 @13800000000"},
+			wantAt:     ding.At{AtUserIds: []string{"u1"}, AtMobiles: []string{"13800000000"}},
+		},
+		{
+			name:       "resolved",
+			body:       resolvedBody,
+			wantStatus: http.StatusOK,
+			wantTitle:  "[RESOLVED] HighErrorRate",
+			wantText:   []string{"已恢复 (1)", "恢复时间: " + time.Date(2024, 5, 1, 10, 5, 0, 0, time.UTC).Local().Format(alertmanager.TimeLayout)},
+		},
+		{
+			name:         "resolved skipped",
+			body:         resolvedBody,
+			skipResolved: true,
+			wantStatus:   http.StatusOK,
+			wantNoSend:   true,
+		},
+		{
+			name:       "invalid body",
+			body:       `{"alerts": [`,
+			wantStatus: http.StatusBadRequest,
+			wantNoSend: true,
+		},
+		{
+			name:       "dingtalk error",
+			body:       firingBody,
+			fault:      &dingtest.Fault{Err: &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast"}},
+			wantStatus: http.StatusBadGateway,
+			wantNoSend: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dingtest.NewServer()
+			defer srv.Close()
+			srv.AddRobot("token", "SECtoken")
+			c, err := srv.NewWhClient("token", "SECtoken")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.fault != nil {
+				srv.InjectFault(dingtest.EndpointWebhook, 1, *tt.fault)
+			}
+
+			f := alertmanager.NewWebhookForwarder(c)
+			f.SkipResolved = tt.skipResolved
+			f.Mentions = tt.mentions
+
+			w := httptest.NewRecorder()
+			f.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/alertmanager", strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+
+			msgs := srv.Messages(dingtest.EndpointWebhook)
+			if tt.wantNoSend {
+				if len(msgs) != 0 {
+					t.Fatalf("recorded %d messages, want 0", len(msgs))
+				}
+				return
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("recorded %d messages, want 1", len(msgs))
+			}
+			got := msgs[0]
+			if got.MsgType != ding.WhMsgTypeMarkdown || got.Title != tt.wantTitle {
+				t.Errorf("msgType, title = %q, %q, want %q, %q", got.MsgType, got.Title, ding.WhMsgTypeMarkdown, tt.wantTitle)
+			}
+			for _, s := range tt.wantText {
+				if !strings.Contains(got.Text, s) {
+					t.Errorf("text does not contain %q:\n%s", s, got.Text)
+				}
+			}
+			if strings.Join(got.At.AtUserIds, ",") != strings.Join(tt.wantAt.AtUserIds, ",") ||
+				strings.Join(got.At.AtMobiles, ",") != strings.Join(tt.wantAt.AtMobiles, ",") ||
+				got.At.IsAtAll != tt.wantAt.IsAtAll {
+				t.Errorf("at = %+v, want %+v", got.At, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestForwardGroupThroughDingtest(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddApp("appKey", "appSecret")
+	c, err := srv.NewGroupClient("robot", "appKey", "appSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := alertmanager.NewGroupForwarder(c, "cid1")
+	msg := &alertmanager.Message{
+		Status:       alertmanager.StatusFiring,
+		CommonLabels: alertmanager.KV{"alertname": "DiskFull"},
+		Alerts: alertmanager.Alerts{{
+			Status:      alertmanager.StatusFiring,
+			Labels:      alertmanager.KV{"alertname": "DiskFull"},
+			Annotations: alertmanager.KV{"summary": "disk is 95% full"},
+			StartsAt:    time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		}},
+	}
+	if err = f.Forward(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := srv.Messages(dingtest.EndpointGroup)
+	if len(msgs) != 1 {
+		t.Fatalf("recorded %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.OpenConversationId != "cid1" || got.MsgType != ding.IMsgKeyMarkdown || got.Title != "[FIRING:1] DiskFull" {
+		t.Errorf("got %+v", got)
+	}
+	if !strings.Contains(got.Text, "disk is 95% full") {
+		t.Errorf("text does not contain the summary:\n%s", got.Text)
+	}
+}
@@ -0,0 +1,44 @@
+package alertmanager
+
+import (
+	"github.com/wanghkkk/ding"
+)
+
+// Sender 把渲染好的markdown消息发送到钉钉
+type Sender interface {
+	// SendMarkdown 发送markdown消息，at为需要@的人，text中已经带上了@的内容
+	SendMarkdown(title, text string, at ding.At) error
+}
+
+// SenderFunc 函数形式的Sender
+type SenderFunc func(title, text string, at ding.At) error
+
+// SendMarkdown 调用函数本身
+func (f SenderFunc) SendMarkdown(title, text string, at ding.At) error {
+	return f(title, text, at)
+}
+
+// WebhookSender 通过webhook方式发送，支持@某人
+func WebhookSender(c *ding.WhClient) Sender {
+	return SenderFunc(func(title, text string, at ding.At) error {
+		msg := ding.NewWhMarkdownMsg(title, text)
+		msg.At = at
+		return c.SendWhMsg(msg)
+	})
+}
+
+// GroupSender 通过接口方式发送到openConversationId这个群，接口方式不支持@某人，只会在正文中展示@的内容
+func GroupSender(c *ding.GroupClient, openConversationId string) Sender {
+	return SenderFunc(func(title, text string, at ding.At) error {
+		return c.SendMarkdownMsg(title, text, openConversationId)
+	})
+}
+
+// DingSender 通过任意 ding.Sender 发送，如 Registry 中的目标
+func DingSender(s ding.Sender) Sender {
+	return SenderFunc(func(title, text string, at ding.At) error {
+		msg := ding.NewMarkdownMessage(title, text)
+		msg.At = at
+		return s.Send(msg)
+	})
+}
@@ -0,0 +1,138 @@
+package alertmanager
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+var (
+	// DefaultTitleTemplate 默认的标题模板，钉钉会在会话列表中展示
+	DefaultTitleTemplate = `[{{ .Status | upper }}{{ if .Firing }}:{{ len .Firing }}{{ end }}] {{ .CommonLabels.alertname }}`
+
+	// DefaultTextTemplate 默认的markdown正文模板
+	DefaultTextTemplate = `### {{ .Title }}
+{{ if .Firing }}
+#### 触发中 ({{ len .Firing }})
+{{ range .Firing }}
+- **{{ .Labels.alertname }}** {{ .Annotations.summary }}
+{{- if .Annotations.description }}
+  - 描述: {{ .Annotations.description }}
+{{- end }}
+  - 开始时间: {{ formatTime .StartsAt }}
+{{- range (.Labels.Remove (list "alertname")).SortedPairs }}
+  - {{ .Name }}: {{ .Value }}
+{{- end }}
+{{- if .GeneratorURL }}
+  - [查看详情]({{ .GeneratorURL }})
+{{- end }}
+{{ end }}
+{{- end }}
+{{- if .Resolved }}
+#### 已恢复 ({{ len .Resolved }})
+{{ range .Resolved }}
+- **{{ .Labels.alertname }}** {{ .Annotations.summary }}
+  - 开始时间: {{ formatTime .StartsAt }}
+  - 恢复时间: {{ formatTime .EndsAt }}
+{{- range (.Labels.Remove (list "alertname")).SortedPairs }}
+  - {{ .Name }}: {{ .Value }}
+{{- end }}
+{{ end }}
+{{- end }}
+{{- if .ExternalURL }}
+[Alertmanager]({{ .ExternalURL }})
+{{- end }}`
+
+	// TimeLayout 模板中 formatTime 使用的时间格式
+	TimeLayout = "2006-01-02 15:04:05"
+)
+
+// TemplateData 渲染模板时的数据，在 Message 的基础上把告警按触发中和已恢复分组
+type TemplateData struct {
+	*Message
+	// 渲染后的标题，只有正文模板中可用
+	Title string
+	// 触发中的告警
+	Firing Alerts
+	// 已恢复的告警
+	Resolved Alerts
+}
+
+// Template 标题和正文模板
+type Template struct {
+	title *template.Template
+	text  *template.Template
+}
+
+var funcs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	"list": func(s ...string) []string {
+		return s
+	},
+	"formatTime": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format(TimeLayout)
+	},
+}
+
+// NewTemplate 解析标题和正文模板，为空时使用默认模板。
+// 模板为 text/template 语法，可以使用 TemplateData 的所有字段，以及 upper、lower、join、list、formatTime 函数
+func NewTemplate(title, text string) (*Template, error) {
+	if title == "" {
+		title = DefaultTitleTemplate
+	}
+	if text == "" {
+		text = DefaultTextTemplate
+	}
+	tt, err := template.New("title").Funcs(funcs).Parse(title)
+	if err != nil {
+		return nil, err
+	}
+	xt, err := template.New("text").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{title: tt, text: xt}, nil
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultTemplate *Template
+	defaultErr      error
+)
+
+// DefaultTemplate 默认模板，第一次使用时解析 DefaultTitleTemplate 和 DefaultTextTemplate，之后不再重复解析
+func DefaultTemplate() (*Template, error) {
+	defaultOnce.Do(func() {
+		defaultTemplate, defaultErr = NewTemplate("", "")
+	})
+	return defaultTemplate, defaultErr
+}
+
+// Render 渲染出钉钉markdown消息的标题和正文
+func (t *Template) Render(msg *Message) (title, text string, err error) {
+	data := &TemplateData{
+		Message:  msg,
+		Firing:   msg.Alerts.Firing(),
+		Resolved: msg.Alerts.Resolved(),
+	}
+	var buf bytes.Buffer
+	if err = t.title.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	data.Title = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err = t.text.Execute(&buf, data); err != nil {
+		return "", "", err
+	}
+	return data.Title, buf.String(), nil
+}
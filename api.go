@@ -0,0 +1,118 @@
+package ding
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// DefaultApiBaseUrl 新版接口的默认地址，客户端可以用 WithBaseUrl 修改
+	DefaultApiBaseUrl = "https://api.dingtalk.com"
+	// DefaultOapiBaseUrl 旧版接口的默认地址，客户端可以用 WithBaseUrl 修改
+	DefaultOapiBaseUrl = "https://oapi.dingtalk.com"
+)
+
+var (
+	// ErrInvalidParam 调用钉钉接口的参数不合法
+	ErrInvalidParam = errors.New("ding: invalid param")
+)
+
+// accessToken 获取客户端所属应用的accessToken
+func (c *IClient) accessToken() (string, error) {
+	tp := c.TokenProvider
+	if tp == nil {
+		tp = DefaultTokenProvider
+	}
+	return tp.AccessToken(c.AppKeySecret)
+}
+
+// httpClient 接口方式请求钉钉使用的 http.Client
+func (c *IClient) httpClient() *http.Client {
+	return c.client(&http.Client{Timeout: DefaultInterfaceTimeout})
+}
+
+// apiUrl 新版接口的完整地址，path以/开头
+func (c *IClient) apiUrl(path string) string {
+	base := c.apiBaseUrl
+	if base == "" {
+		base = DefaultApiBaseUrl
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// oapiUrl 旧版接口的完整地址，path以/开头
+func (c *IClient) oapiUrl(path string) string {
+	base := c.oapiBaseUrl
+	if base == "" {
+		base = DefaultOapiBaseUrl
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+// callApi 调用新版(api.dingtalk.com)接口，accessToken放在请求头中。req为nil时没有请求体，resp不为nil时把回复解析到resp
+func (c *IClient) callApi(method, apiUrl string, req, resp any) error {
+	accessToken, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("x-acs-dingtalk-access-token", accessToken)
+	statusCode, respByte, err := c.doJson(c.httpClient(), method, apiUrl, header, req)
+	if err != nil {
+		return err
+	}
+	c.debugf("请求钉钉接口 %s 后，收到钉钉的回复: %v\n", apiUrl, string(respByte))
+	if err = parseDingResp(statusCode, respByte); err != nil {
+		return err
+	}
+	if resp == nil || len(respByte) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respByte, resp)
+}
+
+// callOapi 调用旧版(oapi.dingtalk.com)接口，accessToken放在url参数中。
+// 旧版接口的数据一般在回复的result字段中，result不为nil时把result字段解析到result
+func (c *IClient) callOapi(apiUrl string, req, result any) error {
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := c.callOapiRaw(apiUrl, req, &resp); err != nil {
+		return err
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Result, result)
+}
+
+// callOapiRaw 调用旧版接口，把整个回复解析到resp，用于数据不在result字段中的接口
+func (c *IClient) callOapiRaw(apiUrl string, req, resp any) error {
+	accessToken, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(apiUrl)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("access_token", accessToken)
+	u.RawQuery = q.Encode()
+
+	statusCode, respByte, err := c.postJson(c.httpClient(), u.String(), nil, req)
+	if err != nil {
+		return err
+	}
+	c.debugf("请求钉钉接口 %s 后，收到钉钉的回复: %v\n", apiUrl, string(respByte))
+	if err = parseDingResp(statusCode, respByte); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(respByte, resp)
+}
@@ -0,0 +1,371 @@
+// 审批，通过独立跳转的ActionCard消息询问值班人员，如“是否回滚？”，按钮是带签名的链接，指向本地的http服务，
+// 打开链接后在确认页面提交，阻塞等待的调用方收到同意、拒绝或者超时的结果。
+// 链接预览、安全扫描等只会GET链接，不会提交确认页面，所以不会误操作审批。
+// 没有设置 Approver.Identify 时无法知道是谁处理的，看到消息、拿到链接的任何人都可以处理审批
+// 用法：
+//
+//	a := ding.NewApprover("https://ops.example.com/ding/approval", secret)
+//	http.Handle("/ding/approval", a)
+//	res, err := a.Ask(ctx, whClient, "oncallUserId", "是否回滚？", "订单服务发布后错误率升高")
+
+package ding
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultApprovalTimeout 审批默认的超时时间
+	DefaultApprovalTimeout = 30 * time.Minute
+
+	// ErrApprovalLinkInvalid 审批链接的签名不正确
+	ErrApprovalLinkInvalid = errors.New("ding: approval link is invalid")
+	// ErrApprovalLinkExpired 审批链接已经过期、已经被使用或者审批已经结束
+	ErrApprovalLinkExpired = errors.New("ding: approval link is expired or already used")
+)
+
+// Decision 审批结果
+type Decision int
+
+const (
+	// DecisionTimeout 超时没有人处理
+	DecisionTimeout Decision = iota
+	// DecisionApprove 同意
+	DecisionApprove
+	// DecisionReject 拒绝
+	DecisionReject
+)
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionTimeout:
+		return "timeout"
+	case DecisionApprove:
+		return "approve"
+	case DecisionReject:
+		return "reject"
+	}
+	return fmt.Sprintf("Decision(%d)", int(d))
+}
+
+func parseDecision(s string) (Decision, bool) {
+	switch s {
+	case "approve":
+		return DecisionApprove, true
+	case "reject":
+		return DecisionReject, true
+	}
+	return DecisionTimeout, false
+}
+
+// ApprovalResult 审批的结果
+type ApprovalResult struct {
+	// 审批id
+	Id string
+	// 同意、拒绝或者超时
+	Decision Decision
+	// 处理的人，由 Approver.Identify 识别，没有设置 Identify 或者超时时为空
+	User string
+	// 点击或者超时的时间
+	Time time.Time
+}
+
+// Approved 是否同意
+func (r *ApprovalResult) Approved() bool {
+	return r.Decision == DecisionApprove
+}
+
+// Approver 审批服务，生成带签名的审批链接，并处理链接的请求，实现了 http.Handler
+type Approver struct {
+	// 审批链接的地址，钉钉客户端需要能访问到，指向挂载 Approver 的路径
+	BaseUrl string
+	// 签名审批链接的密钥
+	Secret string
+	// 审批的超时时间，为0时使用 DefaultApprovalTimeout
+	Timeout time.Duration
+	// 识别处理审批的人，如从公司的单点登录中获取，返回空字符串表示无法识别，拒绝这次处理。
+	// 审批指定了审批人时，只有审批人可以处理。
+	// 为nil时无法知道是谁处理的，也无法限制审批人，拿到链接的任何人都可以处理，ApprovalResult.User 为空
+	Identify func(r *http.Request) string
+
+	mu      sync.Mutex
+	pending map[string]*Approval
+}
+
+// NewApprover 创建审批服务，baseUrl为审批链接的地址，secret为签名的密钥
+func NewApprover(baseUrl, secret string) *Approver {
+	return &Approver{BaseUrl: baseUrl, Secret: secret, pending: map[string]*Approval{}}
+}
+
+// Approval 一个进行中的审批
+type Approval struct {
+	// 审批id
+	Id string
+	// 审批人，写入审批链接并签名，设置了 Approver.Identify 时只有审批人可以处理，为空时任何能识别的人都可以处理
+	User string
+	// 同意的链接
+	ApproveUrl string
+	// 拒绝的链接
+	RejectUrl string
+	// 过期时间
+	Expires time.Time
+
+	approver *Approver
+	done     chan *ApprovalResult
+}
+
+// New 创建一个审批，user为审批人，timeout为0时使用 Approver.Timeout
+func (a *Approver) New(user string, timeout time.Duration) (*Approval, error) {
+	if a.Secret == "" {
+		return nil, fmt.Errorf("%w: approval secret is required", ErrInvalidClientConfig)
+	}
+	if timeout <= 0 {
+		timeout = a.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	p := &Approval{
+		Id:       hex.EncodeToString(b),
+		User:     user,
+		Expires:  time.Now().Add(timeout),
+		approver: a,
+		done:     make(chan *ApprovalResult, 1),
+	}
+	var err error
+	if p.ApproveUrl, err = a.link(p, DecisionApprove); err != nil {
+		return nil, err
+	}
+	if p.RejectUrl, err = a.link(p, DecisionReject); err != nil {
+		return nil, err
+	}
+	a.sweep()
+	a.mu.Lock()
+	if a.pending == nil {
+		a.pending = map[string]*Approval{}
+	}
+	a.pending[p.Id] = p
+	a.mu.Unlock()
+	return p, nil
+}
+
+// sweep 结束已经过期的审批，没有调用 Wait 的审批也不会一直留在内存中
+func (a *Approver) sweep() {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, p := range a.pending {
+		if now.After(p.Expires) {
+			delete(a.pending, id)
+			p.done <- &ApprovalResult{Id: p.Id, Decision: DecisionTimeout, Time: now}
+		}
+	}
+}
+
+// Ask 发送审批消息给c并等待user审批，超时时返回 DecisionTimeout，ctx取消时返回ctx的错误。
+// user为审批人的userId等标识，只有设置了 Identify 时才会限制审批人，为空时任何能识别的人都可以审批
+func (a *Approver) Ask(ctx context.Context, c *WhClient, user, title, text string) (*ApprovalResult, error) {
+	p, err := a.New(user, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.SendWhMsg(p.Msg(title, text)); err != nil {
+		p.Cancel()
+		return nil, err
+	}
+	return p.Wait(ctx)
+}
+
+// sign 审批链接的签名
+func (a *Approver) sign(id string, d Decision, exp, user string) string {
+	return GetSign(id+"\n"+d.String()+"\n"+exp+"\n"+user, a.Secret)
+}
+
+// link 生成审批链接
+func (a *Approver) link(p *Approval, d Decision) (string, error) {
+	u, err := url.Parse(a.BaseUrl)
+	if err != nil {
+		return "", fmt.Errorf("%w: approval baseUrl: %s", ErrInvalidClientConfig, err)
+	}
+	exp := strconv.FormatInt(p.Expires.Unix(), 10)
+	q := u.Query()
+	q.Set("id", p.Id)
+	q.Set("d", d.String())
+	q.Set("exp", exp)
+	if p.User != "" {
+		q.Set("user", p.User)
+	}
+	q.Set("sign", a.sign(p.Id, d, exp, p.User))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// isPending 审批是否还在进行中
+func (a *Approver) isPending(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.pending[id]
+	return ok
+}
+
+// finish 结束审批，只有第一次调用返回true
+func (a *Approver) finish(id string) (*Approval, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[id]
+	if ok {
+		delete(a.pending, id)
+	}
+	return p, ok
+}
+
+// verify 校验审批链接的签名和过期时间
+func (a *Approver) verify(q url.Values) (Decision, error) {
+	d, ok := parseDecision(q.Get("d"))
+	if !ok {
+		return d, ErrApprovalLinkInvalid
+	}
+	exp := q.Get("exp")
+	sign := a.sign(q.Get("id"), d, exp, q.Get("user"))
+	if !hmac.Equal([]byte(sign), []byte(q.Get("sign"))) {
+		return d, ErrApprovalLinkInvalid
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return d, ErrApprovalLinkInvalid
+	}
+	if time.Now().Unix() > expires {
+		return d, ErrApprovalLinkExpired
+	}
+	return d, nil
+}
+
+// ServeHTTP 处理审批链接的请求，校验签名和过期时间，GET返回确认页面，确认页面POST提交后校验处理的人并结束审批，
+// 每个审批只能处理一次
+func (a *Approver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeApprovalPage(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+		return
+	}
+	a.sweep()
+	q := r.URL.Query()
+	d, err := a.verify(q)
+	if errors.Is(err, ErrApprovalLinkInvalid) {
+		writeApprovalPage(w, http.StatusForbidden, "审批链接无效")
+		return
+	}
+	if err != nil {
+		writeApprovalPage(w, http.StatusGone, "审批链接已过期")
+		return
+	}
+	if r.Method == http.MethodGet {
+		if !a.isPending(q.Get("id")) {
+			writeApprovalPage(w, http.StatusGone, "审批已经处理过了")
+			return
+		}
+		writeApprovalConfirmPage(w, d, r.URL.RequestURI())
+		return
+	}
+	var user string
+	if a.Identify != nil {
+		user = a.Identify(r)
+		if user == "" {
+			writeApprovalPage(w, http.StatusForbidden, "无法识别审批人")
+			return
+		}
+		if signed := q.Get("user"); signed != "" && signed != user {
+			writeApprovalPage(w, http.StatusForbidden, "不是这个审批的审批人")
+			return
+		}
+	}
+	p, ok := a.finish(q.Get("id"))
+	if !ok {
+		writeApprovalPage(w, http.StatusGone, "审批已经处理过了")
+		return
+	}
+	p.done <- &ApprovalResult{Id: p.Id, Decision: d, User: user, Time: time.Now()}
+	if d == DecisionApprove {
+		writeApprovalPage(w, http.StatusOK, "已同意")
+	} else {
+		writeApprovalPage(w, http.StatusOK, "已拒绝")
+	}
+}
+
+// ListenAndServe 在addr上单独启动审批服务，审批链接的路径不限
+func (a *Approver) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, a)
+}
+
+func writeApprovalPage(w http.ResponseWriter, code int, text string) {
+	writeApprovalHTML(w, code, "<h3>"+html.EscapeString(text)+"</h3>")
+}
+
+// writeApprovalConfirmPage 确认页面，提交到同一个审批链接
+func writeApprovalConfirmPage(w http.ResponseWriter, d Decision, action string) {
+	text, button := "确认同意？", "同意"
+	if d == DecisionReject {
+		text, button = "确认拒绝？", "拒绝"
+	}
+	writeApprovalHTML(w, http.StatusOK, fmt.Sprintf("<h3>%s</h3><form method=\"post\" action=\"%s\"><button type=\"submit\">%s</button></form>",
+		text, html.EscapeString(action), button))
+}
+
+func writeApprovalHTML(w http.ResponseWriter, code int, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = fmt.Fprintf(w, "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><meta name=\"viewport\" content=\"width=device-width\"></head><body>%s</body></html>", body)
+}
+
+// Btns 同意和拒绝两个按钮，用于 NewWhIndependentActionCardMsg
+func (p *Approval) Btns(approveTitle, rejectTitle string) []*Btn {
+	return []*Btn{NewBtn(approveTitle, p.ApproveUrl), NewBtn(rejectTitle, p.RejectUrl)}
+}
+
+// Msg 审批消息，按钮为同意和拒绝
+func (p *Approval) Msg(title, text string) *WhIndependentActionCardMsg {
+	return NewWhIndependentActionCardMsgWithBtnOrientation(title, text, "1", p.Btns("同意", "拒绝"))
+}
+
+// Wait 等待审批结果，超时时返回 DecisionTimeout，ctx取消时结束审批并返回ctx的错误
+func (p *Approval) Wait(ctx context.Context) (*ApprovalResult, error) {
+	timer := time.NewTimer(time.Until(p.Expires))
+	defer timer.Stop()
+	select {
+	case res := <-p.done:
+		return res, nil
+	case <-timer.C:
+		if _, ok := p.approver.finish(p.Id); ok {
+			return &ApprovalResult{Id: p.Id, Decision: DecisionTimeout, Time: time.Now()}, nil
+		}
+	case <-ctx.Done():
+		if _, ok := p.approver.finish(p.Id); ok {
+			return nil, ctx.Err()
+		}
+	}
+	// 超时的同时有人点击了或者已经取消，以先结束的为准
+	return <-p.done, nil
+}
+
+// Cancel 取消审批，之后审批链接不再有效，Wait 返回 DecisionTimeout
+func (p *Approval) Cancel() {
+	if _, ok := p.approver.finish(p.Id); ok {
+		p.done <- &ApprovalResult{Id: p.Id, Decision: DecisionTimeout, Time: time.Now()}
+	}
+}
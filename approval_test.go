@@ -0,0 +1,200 @@
+package ding_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// click 请求审批链接，返回状态码
+func click(a *ding.Approver, method, link string, header http.Header) int {
+	u, _ := url.Parse(link)
+	r := httptest.NewRequest(method, u.RequestURI(), nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestApproverServeHTTP(t *testing.T) {
+	identify := func(r *http.Request) string { return r.Header.Get("X-User") }
+	as := func(user string) http.Header { return http.Header{"X-User": {user}} }
+
+	tests := []struct {
+		name     string
+		identify func(r *http.Request) string
+		user     string
+		timeout  time.Duration
+		clicks   func(a *ding.Approver, p *ding.Approval) []int
+		want     []int
+		decision ding.Decision
+		by       string
+		pending  bool
+	}{
+		{
+			name: "get shows the confirm page only",
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{click(a, http.MethodGet, p.ApproveUrl, nil), click(a, http.MethodHead, p.ApproveUrl, nil)}
+			},
+			want:    []int{http.StatusOK, http.StatusMethodNotAllowed},
+			pending: true,
+		},
+		{
+			name: "post approves without identify",
+			user: "u1",
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{click(a, http.MethodPost, p.ApproveUrl, nil)}
+			},
+			want:     []int{http.StatusOK},
+			decision: ding.DecisionApprove,
+		},
+		{
+			name: "double click",
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{
+					click(a, http.MethodPost, p.RejectUrl, nil),
+					click(a, http.MethodPost, p.ApproveUrl, nil),
+					click(a, http.MethodGet, p.ApproveUrl, nil),
+				}
+			},
+			want:     []int{http.StatusOK, http.StatusGone, http.StatusGone},
+			decision: ding.DecisionReject,
+		},
+		{
+			name: "tampered link",
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{
+					click(a, http.MethodPost, strings.Replace(p.RejectUrl, "d=reject", "d=approve", 1), nil),
+					click(a, http.MethodPost, p.ApproveUrl+"x", nil),
+				}
+			},
+			want:    []int{http.StatusForbidden, http.StatusForbidden},
+			pending: true,
+		},
+		{
+			name:    "expired link",
+			timeout: time.Second,
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				time.Sleep(2 * time.Second)
+				return []int{click(a, http.MethodPost, p.ApproveUrl, nil)}
+			},
+			want:     []int{http.StatusGone},
+			decision: ding.DecisionTimeout,
+		},
+		{
+			name:     "identify the designated approver",
+			identify: identify,
+			user:     "u1",
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{
+					click(a, http.MethodPost, p.ApproveUrl, nil),
+					click(a, http.MethodPost, p.ApproveUrl, as("u2")),
+					click(a, http.MethodPost, p.ApproveUrl, as("u1")),
+				}
+			},
+			want:     []int{http.StatusForbidden, http.StatusForbidden, http.StatusOK},
+			decision: ding.DecisionApprove,
+			by:       "u1",
+		},
+		{
+			name:     "identify anyone",
+			identify: identify,
+			clicks: func(a *ding.Approver, p *ding.Approval) []int {
+				return []int{click(a, http.MethodPost, p.RejectUrl, as("u2"))}
+			},
+			want:     []int{http.StatusOK},
+			decision: ding.DecisionReject,
+			by:       "u2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := ding.NewApprover("https://ops.example.com/ding/approval", "secret")
+			a.Identify = tt.identify
+			p, err := a.New(tt.user, tt.timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := tt.clicks(a, p)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("click %d: status = %d, want %d", i, got[i], tt.want[i])
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			res, err := p.Wait(ctx)
+			if tt.pending {
+				if err != context.DeadlineExceeded {
+					t.Fatalf("Wait = %+v, %v, want still pending", res, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Decision != tt.decision || res.User != tt.by {
+				t.Errorf("result = %s by %q, want %s by %q", res.Decision, res.User, tt.decision, tt.by)
+			}
+		})
+	}
+}
+
+func TestApproverAsk(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := ding.NewApprover("https://ops.example.com/ding/approval", "secret")
+
+	done := make(chan *ding.ApprovalResult, 1)
+	go func() {
+		res, err := a.Ask(context.Background(), c, "u1", "是否回滚？", "错误率升高")
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+
+	var msgs []*dingtest.Received
+	for i := 0; i < 100 && len(msgs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		msgs = srv.Messages(dingtest.EndpointWebhook)
+	}
+	if len(msgs) != 1 || msgs[0].MsgType != ding.WhMsgTypeActionCard {
+		t.Fatalf("recorded %+v, want one actionCard", msgs)
+	}
+	var body struct {
+		ActionCard struct {
+			Btns []*ding.Btn `json:"btns"`
+		} `json:"actionCard"`
+	}
+	if err = json.Unmarshal(msgs[0].Body, &body); err != nil || len(body.ActionCard.Btns) != 2 {
+		t.Fatalf("buttons = %+v, %v", body.ActionCard.Btns, err)
+	}
+	if code := click(a, http.MethodPost, body.ActionCard.Btns[1].ActionURL, nil); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	select {
+	case res := <-done:
+		if res == nil || res.Decision != ding.DecisionReject {
+			t.Errorf("result = %+v, want reject", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Ask did not return")
+	}
+}
@@ -0,0 +1,78 @@
+package ding
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// DefaultBroadcastWorkers 广播时默认的最大并发数
+	DefaultBroadcastWorkers = 8
+)
+
+// Broadcaster 把同一条消息并发发送到多个目标，如多个webhook机器人、多个群和多组单聊用户
+type Broadcaster struct {
+	// 最大并发数，<=0时使用 DefaultBroadcastWorkers
+	Workers int
+}
+
+// NewBroadcaster 创建广播器，workers为最大并发数
+func NewBroadcaster(workers int) *Broadcaster {
+	return &Broadcaster{Workers: workers}
+}
+
+// BroadcastResult 一个目标的发送结果
+type BroadcastResult struct {
+	// 发送目标
+	Target Sender
+	// 目标的名字，见 TargetName，不同的目标可能同名，只用于展示
+	Name string
+	// 发送的错误，成功时为nil
+	Err error
+}
+
+// Broadcast 并发发送msg到所有targets，等待全部发送完成。
+// 返回每个目标的发送结果，顺序和targets一致；以及所有失败合并后的错误，全部成功时为nil
+func (b *Broadcaster) Broadcast(msg *Message, targets ...Sender) ([]BroadcastResult, error) {
+	workers := b.Workers
+	if workers <= 0 {
+		workers = DefaultBroadcastWorkers
+	}
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+
+	results := make([]BroadcastResult, len(targets))
+	var wg sync.WaitGroup
+	ch := make(chan int)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				// 每个目标只写自己下标的结果，不需要加锁
+				s := targets[i]
+				results[i] = BroadcastResult{Target: s, Name: TargetName(s), Err: s.Send(msg)}
+			}
+		}()
+	}
+	for i := range targets {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Name, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// Broadcast 使用默认并发数广播消息，见 Broadcaster.Broadcast
+func Broadcast(msg *Message, targets ...Sender) ([]BroadcastResult, error) {
+	return (&Broadcaster{}).Broadcast(msg, targets...)
+}
@@ -0,0 +1,230 @@
+// 互动卡片回调，卡片上的按钮被点击后，钉钉把回调推送到注册的地址(HTTP模式)或者通过Stream推送(Stream模式)，
+// 按actionId分发给处理函数，处理函数可以返回新的卡片数据，钉钉收到回复后原地更新卡片
+// 参考： https://open.dingtalk.com/document/orgapp/interactive-card-callback
+// https://open.dingtalk.com/document/orgapp/stream-mode-card-callback
+
+package ding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// CardCallbackTopic Stream模式下卡片回调的topic，注册Stream回调时使用
+	CardCallbackTopic = "/v1.0/card/instances/callback"
+	// DefaultCardCallbackSkew 校验卡片回调和机器人消息签名时允许的最大时间差
+	DefaultCardCallbackSkew = time.Hour
+	// MaxCardCallbackBody 卡片回调请求体的最大字节数
+	MaxCardCallbackBody int64 = 1 << 20
+
+	// ErrInvalidSign 回调请求的签名不正确或者已经过期
+	ErrInvalidSign = errors.New("ding: invalid callback sign")
+	// ErrNoCallbackSecret 没有设置校验回调签名的appSecret，也没有设置 InsecureSkipVerify
+	ErrNoCallbackSecret = errors.New("ding: callback secret is required to verify requests")
+	// ErrNoCardActionHandler 卡片回调的actionId没有对应的处理函数
+	ErrNoCardActionHandler = errors.New("ding: no handler for card action")
+)
+
+// CardCallback 卡片回调，用户点击了卡片上的按钮
+type CardCallback struct {
+	// 卡片的唯一id，发送卡片时的outTrackId
+	OutTrackId string `json:"outTrackId"`
+	// 企业id
+	CorpId string `json:"corpId"`
+	// 点击按钮的用户
+	UserId string `json:"userId"`
+	// 回调内容，json字符串，解析后放在 ActionIds 和 Params 中
+	Content string `json:"content"`
+	// 按钮的actionId，一般只有一个
+	ActionIds []string `json:"-"`
+	// 按钮回传的参数
+	Params map[string]any `json:"-"`
+}
+
+// ActionId 返回第一个actionId
+func (cb *CardCallback) ActionId() string {
+	if len(cb.ActionIds) == 0 {
+		return ""
+	}
+	return cb.ActionIds[0]
+}
+
+// Param 返回字符串类型的回传参数，不存在或者不是字符串时返回空字符串
+func (cb *CardCallback) Param(key string) string {
+	s, _ := cb.Params[key].(string)
+	return s
+}
+
+// ParseCardCallback 解析卡片回调的请求体
+func ParseCardCallback(body []byte) (*CardCallback, error) {
+	var cb CardCallback
+	if err := json.Unmarshal(body, &cb); err != nil {
+		return nil, fmt.Errorf("ding: parse card callback: %w", err)
+	}
+	if cb.Content == "" {
+		return &cb, nil
+	}
+	var content struct {
+		CardPrivateData struct {
+			ActionIds []string       `json:"actionIds"`
+			Params    map[string]any `json:"params"`
+		} `json:"cardPrivateData"`
+	}
+	if err := json.Unmarshal([]byte(cb.Content), &content); err != nil {
+		return nil, fmt.Errorf("ding: parse card callback content: %w", err)
+	}
+	cb.ActionIds = content.CardPrivateData.ActionIds
+	cb.Params = content.CardPrivateData.Params
+	return &cb, nil
+}
+
+// CardCallbackResponse 卡片回调的回复，用于原地更新卡片，字段为nil时不更新
+type CardCallbackResponse struct {
+	// 更新所有人看到的卡片数据，只更新其中的变量
+	CardData CardData
+	// 更新点击者自己看到的卡片数据，如按钮置灰
+	PrivateData CardData
+}
+
+// MarshalJSON 序列化成钉钉要求的回复格式
+func (r *CardCallbackResponse) MarshalJSON() ([]byte, error) {
+	body := map[string]any{}
+	options := map[string]bool{}
+	if r.CardData != nil {
+		body["cardData"] = map[string]any{"cardParamMap": r.CardData}
+		options["updateCardDataByKey"] = true
+	}
+	if r.PrivateData != nil {
+		body["userPrivateData"] = map[string]any{"cardParamMap": r.PrivateData}
+		options["updatePrivateDataByKey"] = true
+	}
+	if len(options) > 0 {
+		body["cardUpdateOptions"] = options
+	}
+	return json.Marshal(body)
+}
+
+// CardActionHandler 卡片按钮的处理函数，返回nil时不更新卡片，返回error时钉钉提示用户操作失败
+type CardActionHandler func(cb *CardCallback) (*CardCallbackResponse, error)
+
+// CardCallbackHandler 卡片回调的处理器，实现了 http.Handler，Stream模式使用 CardCallbackHandler.HandleStream
+type CardCallbackHandler struct {
+	// 应用的appSecret，用于校验HTTP回调的签名，为空且没有设置 InsecureSkipVerify 时拒绝所有HTTP回调
+	Secret string
+	// 为true时不校验HTTP回调的签名，任何能访问到回调地址的人都可以伪造点击，只用于本地调试或者前面已经有校验
+	InsecureSkipVerify bool
+	// 签名的timestamp和当前时间允许的最大时间差，为0时使用 DefaultCardCallbackSkew
+	MaxSkew time.Duration
+	// 没有匹配的actionId时调用，为nil时返回 ErrNoCardActionHandler
+	Default CardActionHandler
+	// 处理失败时调用，用于记录日志
+	OnError func(cb *CardCallback, err error)
+
+	mu       sync.RWMutex
+	handlers map[string]CardActionHandler
+}
+
+// NewCardCallbackHandler 创建卡片回调的处理器，secret为应用的appSecret。
+// 不校验签名需要显式设置 InsecureSkipVerify，只用 Stream模式时secret可以为空
+func NewCardCallbackHandler(secret string) *CardCallbackHandler {
+	return &CardCallbackHandler{Secret: secret, handlers: map[string]CardActionHandler{}}
+}
+
+// Handle 注册actionId的处理函数，重复注册时覆盖
+func (h *CardCallbackHandler) Handle(actionId string, fn CardActionHandler) *CardCallbackHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = map[string]CardActionHandler{}
+	}
+	h.handlers[actionId] = fn
+	return h
+}
+
+// Dispatch 把回调分发给actionId的处理函数
+func (h *CardCallbackHandler) Dispatch(cb *CardCallback) (*CardCallbackResponse, error) {
+	h.mu.RLock()
+	fn, ok := h.handlers[cb.ActionId()]
+	h.mu.RUnlock()
+	if !ok {
+		fn = h.Default
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoCardActionHandler, cb.ActionId())
+	}
+	resp, err := fn(cb)
+	if err != nil && h.OnError != nil {
+		h.OnError(cb, err)
+	}
+	return resp, err
+}
+
+// HandleStream Stream模式的回调，data为 CardCallbackTopic 推送的数据，返回值作为回复发送给钉钉。
+// Stream连接已经鉴权，不再校验签名
+func (h *CardCallbackHandler) HandleStream(data []byte) ([]byte, error) {
+	cb, err := ParseCardCallback(data)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.Dispatch(cb)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil {
+		resp = &CardCallbackResponse{}
+	}
+	return json.Marshal(resp)
+}
+
+// ServeHTTP HTTP模式的回调，签名在请求头的timestamp和sign中。
+// 没有设置 Secret 时返回500，见 ErrNoCallbackSecret，除非设置了 InsecureSkipVerify
+func (h *CardCallbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.Secret == "" && !h.InsecureSkipVerify {
+		http.Error(w, ErrNoCallbackSecret.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !h.InsecureSkipVerify {
+		skew := h.MaxSkew
+		if skew == 0 {
+			skew = DefaultCardCallbackSkew
+		}
+		if !CheckDingSign(r.Header.Get("timestamp"), r.Header.Get("sign"), h.Secret, skew) {
+			http.Error(w, ErrInvalidSign.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxCardCallbackBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cb, err := ParseCardCallback(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := h.Dispatch(cb)
+	if errors.Is(err, ErrNoCardActionHandler) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resp == nil {
+		resp = &CardCallbackResponse{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
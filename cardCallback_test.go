@@ -0,0 +1,153 @@
+package ding_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+)
+
+// cardBody 构造卡片回调的请求体
+func cardBody(actionId string, params map[string]any) string {
+	content, _ := json.Marshal(map[string]any{
+		"cardPrivateData": map[string]any{"actionIds": []string{actionId}, "params": params},
+	})
+	body, _ := json.Marshal(map[string]any{
+		"outTrackId": "track-1",
+		"userId":     "u1",
+		"content":    string(content),
+	})
+	return string(body)
+}
+
+// signHeader 用secret给时间t签名
+func signHeader(secret string, t time.Time) http.Header {
+	ts := strconv.FormatInt(t.UnixMilli(), 10)
+	return http.Header{"Timestamp": {ts}, "Sign": {ding.GetDingSign(ts, secret)}}
+}
+
+func TestCardCallbackServeHTTP(t *testing.T) {
+	const secret = "app-secret"
+	approve := func(cb *ding.CardCallback) (*ding.CardCallbackResponse, error) {
+		return &ding.CardCallbackResponse{CardData: ding.CardData{"status": cb.Param("id") + " approved by " + cb.UserId}}, nil
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		insecure bool
+		method   string
+		header   http.Header
+		action   string
+		want     int
+		wantBody string
+	}{
+		{name: "valid sign", secret: secret, header: signHeader(secret, time.Now()), action: "approve", want: http.StatusOK, wantBody: "42 approved by u1"},
+		{name: "wrong secret", secret: secret, header: signHeader("other", time.Now()), action: "approve", want: http.StatusUnauthorized},
+		{name: "no sign", secret: secret, action: "approve", want: http.StatusUnauthorized},
+		{name: "expired sign", secret: secret, header: signHeader(secret, time.Now().Add(-2*time.Hour)), action: "approve", want: http.StatusUnauthorized},
+		{name: "missing secret", action: "approve", want: http.StatusInternalServerError, wantBody: ding.ErrNoCallbackSecret.Error()},
+		{name: "missing secret with sign", header: signHeader("", time.Now()), action: "approve", want: http.StatusInternalServerError},
+		{name: "insecure skip", insecure: true, action: "approve", want: http.StatusOK, wantBody: "42 approved by u1"},
+		{name: "insecure skip ignores bad sign", secret: secret, insecure: true, header: signHeader("other", time.Now()), action: "approve", want: http.StatusOK},
+		{name: "unknown action", secret: secret, header: signHeader(secret, time.Now()), action: "reject", want: http.StatusNotFound},
+		{name: "get", secret: secret, method: http.MethodGet, header: signHeader(secret, time.Now()), action: "approve", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ding.NewCardCallbackHandler(tt.secret).Handle("approve", approve)
+			h.InsecureSkipVerify = tt.insecure
+			method := tt.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, "/ding/card", strings.NewReader(cardBody(tt.action, map[string]any{"id": "42"})))
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d, body: %s", w.Code, tt.want, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want containing %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCardCallbackDispatch(t *testing.T) {
+	var mu sync.Mutex
+	var failed []string
+	h := ding.NewCardCallbackHandler("")
+	h.OnError = func(cb *ding.CardCallback, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, cb.ActionId())
+	}
+	h.Handle("ok", func(cb *ding.CardCallback) (*ding.CardCallbackResponse, error) { return nil, nil })
+	h.Handle("fail", func(cb *ding.CardCallback) (*ding.CardCallbackResponse, error) { return nil, errors.New("boom") })
+
+	// Stream模式不校验签名，secret为空也可以处理
+	out, err := h.HandleStream([]byte(cardBody("ok", nil)))
+	if err != nil || string(out) != "{}" {
+		t.Fatalf("HandleStream = %s, %v, want {}", out, err)
+	}
+	if _, err := h.HandleStream([]byte(cardBody("fail", nil))); err == nil {
+		t.Fatal("HandleStream fail action returned nil error")
+	}
+	if _, err := h.HandleStream([]byte(cardBody("missing", nil))); !errors.Is(err, ding.ErrNoCardActionHandler) {
+		t.Fatalf("HandleStream missing action = %v, want ErrNoCardActionHandler", err)
+	}
+
+	h.Default = func(cb *ding.CardCallback) (*ding.CardCallbackResponse, error) {
+		return &ding.CardCallbackResponse{PrivateData: ding.CardData{"done": "true"}}, nil
+	}
+	out, err = h.HandleStream([]byte(cardBody("missing", nil)))
+	if err != nil || !strings.Contains(string(out), `"updatePrivateDataByKey":true`) {
+		t.Fatalf("HandleStream default = %s, %v", out, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				h.Handle("dyn"+strconv.Itoa(i), func(cb *ding.CardCallback) (*ding.CardCallbackResponse, error) { return nil, nil })
+			}
+			_, _ = h.HandleStream([]byte(cardBody("fail", nil)))
+		}(i)
+	}
+	wg.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failed) != 21 {
+		t.Errorf("OnError called %d times, want 21", len(failed))
+	}
+}
+
+func TestParseCardCallback(t *testing.T) {
+	cb, err := ding.ParseCardCallback([]byte(cardBody("approve", map[string]any{"id": "7", "n": 1.0})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.ActionId() != "approve" || cb.Param("id") != "7" || cb.Param("n") != "" || cb.UserId != "u1" || cb.OutTrackId != "track-1" {
+		t.Errorf("ParseCardCallback = %+v", cb)
+	}
+	if _, err := ding.ParseCardCallback([]byte(`{"content":"not json"}`)); err == nil {
+		t.Error("ParseCardCallback accepted invalid content")
+	}
+	cb, err = ding.ParseCardCallback([]byte(`{"userId":"u2"}`))
+	if err != nil || cb.ActionId() != "" {
+		t.Errorf("ParseCardCallback without content = %+v, %v", cb, err)
+	}
+}
@@ -0,0 +1,535 @@
+// 机器人命令路由，解析@机器人的消息，如 "@机器人 deploy rollback order-service --version v1.2 --force"，
+// 按命令和子命令分发给处理函数，自动生成help，用法错误和执行结果通过会话的sessionWebhook回复
+// 参考： https://open.dingtalk.com/document/orgapp/receive-message
+
+package ding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// HelpCommand 自动生成的帮助命令
+	HelpCommand = "help"
+	// MaxPostReqBody 机器人消息请求体的最大字节数
+	MaxPostReqBody int64 = 1 << 20
+
+	// ErrCommandPanic 命令的处理函数panic，ServeHTTP 恢复后交给 OnError
+	ErrCommandPanic = errors.New("ding: command panicked")
+)
+
+// UsageError 命令的用法错误，回复错误和命令的用法
+type UsageError struct {
+	// 出错的命令
+	Command *Command
+	// 错误信息
+	Msg string
+}
+
+func (e *UsageError) Error() string {
+	return "ding: usage error: " + e.Msg
+}
+
+// CommandHandler 命令的处理函数，返回 UsageError 时回复命令的用法，返回其他错误时回复执行失败
+type CommandHandler func(ctx *CommandContext) error
+
+// CommandContext 一次命令调用
+type CommandContext struct {
+	// 钉钉发来的消息
+	Req *PostReq
+	// 匹配的命令
+	Command *Command
+	// 去掉@机器人之后的消息内容
+	Text string
+	// 位置参数
+	Args []string
+	// 选项的值，包含默认值，bool选项为 "true" 或 "false"
+	Flags map[string]string
+
+	router *Router
+}
+
+// Arg 第i个位置参数，不存在时返回空字符串
+func (ctx *CommandContext) Arg(i int) string {
+	if i < 0 || i >= len(ctx.Args) {
+		return ""
+	}
+	return ctx.Args[i]
+}
+
+// Flag 选项的值
+func (ctx *CommandContext) Flag(name string) string {
+	return ctx.Flags[name]
+}
+
+// BoolFlag bool选项是否设置
+func (ctx *CommandContext) BoolFlag(name string) bool {
+	return ctx.Flags[name] == "true"
+}
+
+// Reply 通过会话的sessionWebhook回复消息
+func (ctx *CommandContext) Reply(msg *Message) error {
+	return ctx.router.reply(ctx.Req, msg)
+}
+
+// ReplyText 回复文本消息
+func (ctx *CommandContext) ReplyText(content string) error {
+	return ctx.Reply(NewTextMessage(content))
+}
+
+// ReplyMarkdown 回复markdown消息
+func (ctx *CommandContext) ReplyMarkdown(title, text string) error {
+	return ctx.Reply(NewMarkdownMessage(title, text))
+}
+
+// UsageErrorf 返回当前命令的用法错误
+func (ctx *CommandContext) UsageErrorf(format string, a ...any) error {
+	return &UsageError{Command: ctx.Command, Msg: fmt.Sprintf(format, a...)}
+}
+
+// cmdFlag 命令的选项
+type cmdFlag struct {
+	name   string
+	def    string
+	usage  string
+	isBool bool
+}
+
+// Command 命令，可以有子命令
+type Command struct {
+	// 命令名
+	Name string
+	// 命令的说明，显示在help中
+	Description string
+	// 位置参数的用法，如 "<service> [version]"
+	ArgsUsage string
+	// 最少的位置参数个数，不够时回复用法错误
+	MinArgs int
+
+	handler  CommandHandler
+	parent   *Command
+	subs     map[string]*Command
+	flags    map[string]*cmdFlag
+	flagList []*cmdFlag
+	policies []Policy
+}
+
+func newCommand(parent *Command, name, description string, handler CommandHandler) *Command {
+	return &Command{Name: name, Description: description, handler: handler, parent: parent}
+}
+
+// Command 注册子命令，handler为nil时只用于分组，调用时回复子命令列表
+func (c *Command) Command(name, description string, handler CommandHandler) *Command {
+	if c.subs == nil {
+		c.subs = map[string]*Command{}
+	}
+	sub := newCommand(c, name, description, handler)
+	c.subs[strings.ToLower(name)] = sub
+	return sub
+}
+
+// Args 设置位置参数的用法和最少个数
+func (c *Command) Args(usage string, min int) *Command {
+	c.ArgsUsage, c.MinArgs = usage, min
+	return c
+}
+
+// Flag 注册字符串选项，用 --name value 或 --name=value 传入
+func (c *Command) Flag(name, def, usage string) *Command {
+	return c.addFlag(&cmdFlag{name: name, def: def, usage: usage})
+}
+
+// BoolFlag 注册bool选项，用 --name 传入
+func (c *Command) BoolFlag(name, usage string) *Command {
+	return c.addFlag(&cmdFlag{name: name, def: "false", usage: usage, isBool: true})
+}
+
+func (c *Command) addFlag(f *cmdFlag) *Command {
+	if c.flags == nil {
+		c.flags = map[string]*cmdFlag{}
+	}
+	if _, ok := c.flags[f.name]; !ok {
+		c.flagList = append(c.flagList, f)
+	}
+	c.flags[f.name] = f
+	return c
+}
+
+// Path 完整的命令，如 "deploy rollback"
+func (c *Command) Path() string {
+	var names []string
+	for p := c; p != nil && p.parent != nil; p = p.parent {
+		names = append([]string{p.Name}, names...)
+	}
+	return strings.Join(names, " ")
+}
+
+// subCommands 按名称排序的子命令
+func (c *Command) subCommands() []*Command {
+	keys := make([]string, 0, len(c.subs))
+	for k := range c.subs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]*Command, len(keys))
+	for i, k := range keys {
+		res[i] = c.subs[k]
+	}
+	return res
+}
+
+// Usage 命令的用法，markdown格式
+func (c *Command) Usage() string {
+	var b strings.Builder
+	line := c.Path()
+	if len(c.subs) > 0 && c.handler == nil {
+		line += " <子命令>"
+	}
+	if len(c.flagList) > 0 {
+		line += " [选项]"
+	}
+	if c.ArgsUsage != "" {
+		line += " " + c.ArgsUsage
+	}
+	fmt.Fprintf(&b, "用法：`%s`\n\n", strings.TrimSpace(line))
+	if c.Description != "" {
+		fmt.Fprintf(&b, "%s\n\n", c.Description)
+	}
+	if subs := c.subCommands(); len(subs) > 0 {
+		b.WriteString("子命令：\n\n")
+		for _, s := range subs {
+			fmt.Fprintf(&b, "- `%s` %s\n", s.Name, s.Description)
+		}
+		b.WriteString("\n")
+	}
+	if len(c.flagList) > 0 {
+		b.WriteString("选项：\n\n")
+		for _, f := range c.flagList {
+			if f.isBool || f.def == "" {
+				fmt.Fprintf(&b, "- `--%s` %s\n", f.name, f.usage)
+			} else {
+				fmt.Fprintf(&b, "- `--%s` %s（默认 %s）\n", f.name, f.usage, f.def)
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseFlags 把tokens分成选项和位置参数，"--" 之后都是位置参数，-1 这样的负数是位置参数
+func (c *Command) parseFlags(tokens []string) (map[string]string, []string, error) {
+	flags := map[string]string{}
+	for _, f := range c.flagList {
+		flags[f.name] = f.def
+	}
+	var args []string
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if t == "--" {
+			args = append(args, tokens[i+1:]...)
+			break
+		}
+		if len(t) < 2 || t[0] != '-' || isNumber(t) {
+			args = append(args, t)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(t, "-"), "=")
+		f, ok := c.flags[name]
+		if !ok {
+			return nil, nil, &UsageError{Command: c, Msg: fmt.Sprintf("未知的选项 %s", t)}
+		}
+		switch {
+		case hasValue:
+		case f.isBool:
+			value = "true"
+		case i+1 < len(tokens):
+			i++
+			value = tokens[i]
+		default:
+			return nil, nil, &UsageError{Command: c, Msg: fmt.Sprintf("选项 --%s 需要一个值", name)}
+		}
+		flags[name] = value
+	}
+	return flags, args, nil
+}
+
+// isNumber 是否为数字，如 -1、-0.5
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// Router 机器人命令路由，实现了 http.Handler，可以直接作为机器人的消息接收地址
+type Router struct {
+	// 机器人的名称，显示在help中，也用于去掉消息开头的@机器人
+	Name string
+	// 应用的appSecret，用于校验消息的签名，为空时不校验，这时不能设置权限策略
+	Secret string
+	// 签名的timestamp和当前时间允许的最大时间差，为0时使用 DefaultCardCallbackSkew
+	MaxSkew time.Duration
+	// 创建回复客户端的选项
+	Options []Option
+	// 回复消息，为nil时通过消息的sessionWebhook回复
+	ReplyFunc func(req *PostReq, msg *Message) error
+	// 命令出错或者panic时调用，用于记录日志，为nil时 ServeHTTP 用标准库的log记录
+	OnError func(req *PostReq, err error)
+	// 没有权限时调用，用于审计，拒绝不会交给 OnError，Dispatch 也不会返回错误
+	OnDenied func(rec *DeniedRecord)
+	// 没有权限时回复的消息，为nil时回复默认的消息，返回nil时不回复
+	DenyReply func(rec *DeniedRecord) *Message
+
+	root *Command
+}
+
+// NewRouter 创建机器人命令路由，name为机器人的名称
+func NewRouter(name string) *Router {
+	return &Router{Name: name, root: newCommand(nil, "", "", nil)}
+}
+
+// Command 注册命令
+func (r *Router) Command(name, description string, handler CommandHandler) *Command {
+	return r.root.Command(name, description, handler)
+}
+
+// Help 所有命令的帮助，markdown格式
+func (r *Router) Help() string {
+	var b strings.Builder
+	if r.Name != "" {
+		fmt.Fprintf(&b, "#### %s\n\n", r.Name)
+	}
+	b.WriteString("命令：\n\n")
+	for _, c := range r.root.subCommands() {
+		fmt.Fprintf(&b, "- `%s` %s\n", c.Name, c.Description)
+	}
+	fmt.Fprintf(&b, "\n发送 `%s <命令>` 查看命令的用法", HelpCommand)
+	return b.String()
+}
+
+// StripMention 去掉消息开头的 @robotName 和空白。钉钉发来的消息一般已经去掉了@机器人，
+// 这里只按机器人的名称去掉，机器人的名称可以有空格，不会把其他以@开头的参数当作@机器人
+func StripMention(content, robotName string) string {
+	s := strings.TrimSpace(content)
+	if robotName != "" {
+		if rest, ok := strings.CutPrefix(s, "@"+robotName); ok {
+			s = strings.TrimSpace(rest)
+		}
+	}
+	return s
+}
+
+// splitCommandLine 按空白分割命令行，支持单引号和双引号
+func splitCommandLine(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	var quote rune
+	inToken := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'' || r == '“' || r == '‘':
+			quote = r
+			if r == '“' {
+				quote = '”'
+			} else if r == '‘' {
+				quote = '’'
+			}
+			inToken = true
+		case unicode.IsSpace(r):
+			if inToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inToken = false
+			}
+		default:
+			cur.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("引号没有闭合")
+	}
+	if inToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+// match 匹配最长的命令路径，返回命令和剩余的tokens
+func (r *Router) match(tokens []string) (*Command, []string) {
+	c := r.root
+	for len(tokens) > 0 {
+		sub, ok := c.subs[strings.ToLower(tokens[0])]
+		if !ok {
+			break
+		}
+		c, tokens = sub, tokens[1:]
+	}
+	return c, tokens
+}
+
+// Dispatch 解析消息并执行命令，用法错误和执行失败会回复给发送者，返回命令或者回复的错误
+func (r *Router) Dispatch(req *PostReq) error {
+	err := r.dispatch(req)
+	if err != nil && r.OnError != nil {
+		r.OnError(req, err)
+	}
+	return err
+}
+
+func (r *Router) dispatch(req *PostReq) error {
+	text := StripMention(req.Text.Content, r.Name)
+	tokens, err := splitCommandLine(text)
+	if err != nil {
+		return r.replyError(req, &UsageError{Msg: err.Error()})
+	}
+	if len(tokens) == 0 {
+		return r.reply(req, NewMarkdownMessage("帮助", r.Help()))
+	}
+	if strings.EqualFold(tokens[0], HelpCommand) {
+		c, rest := r.match(tokens[1:])
+		if c == r.root {
+			return r.reply(req, NewMarkdownMessage("帮助", r.Help()))
+		}
+		if len(rest) > 0 {
+			return r.replyError(req, &UsageError{Command: c, Msg: fmt.Sprintf("未知的子命令 %s", rest[0])})
+		}
+		return r.reply(req, NewMarkdownMessage("帮助", c.Usage()))
+	}
+	c, rest := r.match(tokens)
+	if c == r.root {
+		return r.replyError(req, &UsageError{Msg: fmt.Sprintf("未知的命令 %s", tokens[0])})
+	}
+	for _, t := range rest {
+		if t == "-h" || t == "--help" {
+			return r.reply(req, NewMarkdownMessage("帮助", c.Usage()))
+		}
+	}
+	if ok, err := r.authorize(c, req, text); !ok {
+		return err
+	}
+	if c.handler == nil {
+		if len(rest) > 0 {
+			return r.replyError(req, &UsageError{Command: c, Msg: fmt.Sprintf("未知的子命令 %s", rest[0])})
+		}
+		return r.reply(req, NewMarkdownMessage("帮助", c.Usage()))
+	}
+	flags, args, err := c.parseFlags(rest)
+	if err != nil {
+		return r.replyError(req, err)
+	}
+	if len(args) < c.MinArgs {
+		return r.replyError(req, &UsageError{Command: c, Msg: fmt.Sprintf("至少需要 %d 个参数", c.MinArgs)})
+	}
+	ctx := &CommandContext{Req: req, Command: c, Text: text, Args: args, Flags: flags, router: r}
+	if err = c.handler(ctx); err != nil {
+		return r.replyError(req, err)
+	}
+	return nil
+}
+
+// replyError 回复错误，用法错误时附带命令的用法，返回err
+func (r *Router) replyError(req *PostReq, err error) error {
+	var text string
+	var ue *UsageError
+	if errors.As(err, &ue) {
+		text = "用法错误：" + ue.Msg
+		if ue.Command != nil {
+			text += "\n\n" + ue.Command.Usage()
+		} else {
+			text += "\n\n" + r.Help()
+		}
+	} else {
+		text = "执行失败：" + err.Error()
+	}
+	if replyErr := r.reply(req, NewMarkdownMessage("错误", text)); replyErr != nil {
+		return errors.Join(err, replyErr)
+	}
+	return err
+}
+
+// reply 回复消息
+func (r *Router) reply(req *PostReq, msg *Message) error {
+	if r.ReplyFunc != nil {
+		return r.ReplyFunc(req, msg)
+	}
+	if req.SessionWebhook == "" {
+		return fmt.Errorf("%w: message has no sessionWebhook", ErrInvalidParam)
+	}
+	return NewWhClientUseSessionWebhook(req.SessionWebhook, r.Options...).Send(msg)
+}
+
+// ServeHTTP 接收钉钉发来的机器人消息，设置了 Router.Secret 时校验请求头的签名，
+// 设置了权限策略而没有设置 Router.Secret 时拒绝所有请求，见 ErrPolicyWithoutSecret。
+// 先回复钉钉再在新的goroutine中执行命令，命令执行时间长也不会让钉钉超时重发消息，结果通过 Dispatch 回复，
+// 命令panic时恢复，回复执行失败，并把 ErrCommandPanic 交给 OnError
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Secret == "" && r.root.hasPolicies() {
+		http.Error(w, ErrPolicyWithoutSecret.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Secret != "" {
+		skew := r.MaxSkew
+		if skew == 0 {
+			skew = DefaultCardCallbackSkew
+		}
+		if !CheckDingSign(req.Header.Get("timestamp"), req.Header.Get("sign"), r.Secret, skew) {
+			http.Error(w, ErrInvalidSign.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, MaxPostReqBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var p PostReq
+	if err = json.Unmarshal(body, &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 命令的结果通过sessionWebhook回复，这里只返回空的回复，错误交给 OnError
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+	go r.dispatchAsync(&p)
+}
+
+// dispatchAsync ServeHTTP 在新的goroutine中执行命令，没有调用方处理错误和panic，都交给 OnError
+func (r *Router) dispatchAsync(req *PostReq) {
+	defer func() {
+		if v := recover(); v != nil {
+			err := fmt.Errorf("%w: %v\n%s", ErrCommandPanic, v, debug.Stack())
+			_ = r.reply(req, NewMarkdownMessage("错误", "执行失败：内部错误"))
+			r.onError(req, err)
+		}
+	}()
+	err := r.dispatch(req)
+	if err != nil {
+		r.onError(req, err)
+	}
+}
+
+// onError 把错误交给 OnError，为nil时记录日志
+func (r *Router) onError(req *PostReq, err error) {
+	if r.OnError != nil {
+		r.OnError(req, err)
+		return
+	}
+	log.Printf("ding: robot command %q: %v", req.Text.Content, err)
+}
@@ -0,0 +1,186 @@
+package ding
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripMention(t *testing.T) {
+	tests := []struct {
+		content, name, want string
+	}{
+		{" @Deploy Bot  scale -1 ", "Deploy Bot", "scale -1"},
+		{"scale 2", "Deploy Bot", "scale 2"},
+		{"@alice deploy", "Deploy Bot", "@alice deploy"},
+		{"@Deploy Bot deploy @alice", "Deploy Bot", "deploy @alice"},
+		{"@Bot deploy", "", "@Bot deploy"},
+	}
+	for _, tt := range tests {
+		if got := StripMention(tt.content, tt.name); got != tt.want {
+			t.Errorf("StripMention(%q, %q) = %q, want %q", tt.content, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "deploy  rollback\torder", want: []string{"deploy", "rollback", "order"}},
+		{line: `deploy --msg "hot fix" 'a b'`, want: []string{"deploy", "--msg", "hot fix", "a b"}},
+		{line: `deploy --msg “热修复 v2” ‘x’`, want: []string{"deploy", "--msg", "热修复 v2", "x"}},
+		{line: `deploy ""`, want: []string{"deploy", ""}},
+		{line: "", want: nil},
+		{line: `deploy "order`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := splitCommandLine(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitCommandLine(%q) err = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	c := newCommand(nil, "deploy", "", nil).
+		Flag("version", "latest", "版本").
+		BoolFlag("force", "强制")
+	tests := []struct {
+		name      string
+		tokens    []string
+		wantFlags map[string]string
+		wantArgs  []string
+		wantErr   bool
+	}{
+		{name: "defaults", tokens: []string{"order"}, wantFlags: map[string]string{"version": "latest", "force": "false"}, wantArgs: []string{"order"}},
+		{name: "separate value", tokens: []string{"--version", "v1", "order"}, wantFlags: map[string]string{"version": "v1", "force": "false"}, wantArgs: []string{"order"}},
+		{name: "equals value", tokens: []string{"order", "--version=v2", "--force"}, wantFlags: map[string]string{"version": "v2", "force": "true"}, wantArgs: []string{"order"}},
+		{name: "single dash", tokens: []string{"-force", "order"}, wantFlags: map[string]string{"version": "latest", "force": "true"}, wantArgs: []string{"order"}},
+		{name: "negative numbers", tokens: []string{"-1", "-0.5", "--version", "-2"}, wantFlags: map[string]string{"version": "-2", "force": "false"}, wantArgs: []string{"-1", "-0.5"}},
+		{name: "double dash", tokens: []string{"--force", "--", "--version", "-x"}, wantFlags: map[string]string{"version": "latest", "force": "true"}, wantArgs: []string{"--version", "-x"}},
+		{name: "lone dash", tokens: []string{"-"}, wantFlags: map[string]string{"version": "latest", "force": "false"}, wantArgs: []string{"-"}},
+		{name: "unknown flag", tokens: []string{"--dry-run"}, wantErr: true},
+		{name: "missing value", tokens: []string{"--version"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, args, err := c.parseFlags(tt.tokens)
+			if tt.wantErr {
+				var ue *UsageError
+				if !errors.As(err, &ue) || ue.Command != c {
+					t.Fatalf("err = %v, want a UsageError for the command", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(flags, tt.wantFlags) || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("flags, args = %v, %q, want %v, %q", flags, args, tt.wantFlags, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRouterDispatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		wantRun   string
+		wantArgs  []string
+		wantReply string
+		wantErr   bool
+	}{
+		{name: "command with args", text: "@Bot deploy order -1 --force", wantRun: "deploy", wantArgs: []string{"order", "-1"}},
+		{name: "subcommand case insensitive", text: "Deploy Rollback order", wantRun: "deploy rollback", wantArgs: []string{"order"}},
+		{name: "empty shows help", text: "@Bot", wantReply: "deploy"},
+		{name: "help subcommand", text: "help deploy rollback", wantReply: "用法：`deploy rollback"},
+		{name: "unknown command", text: "restart", wantReply: "未知的命令 restart", wantErr: true},
+		{name: "too few args", text: "deploy", wantReply: "至少需要 1 个参数", wantErr: true},
+		{name: "unknown flag", text: "deploy order --dry", wantReply: "未知的选项 --dry", wantErr: true},
+		{name: "usage error from handler", text: "deploy bad", wantReply: "不支持的服务", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var run string
+			var args []string
+			var replies []string
+			r := NewRouter("Bot")
+			r.ReplyFunc = func(req *PostReq, msg *Message) error {
+				replies = append(replies, msg.Text)
+				return nil
+			}
+			handler := func(ctx *CommandContext) error {
+				if ctx.Arg(0) == "bad" {
+					return ctx.UsageErrorf("不支持的服务 %s", ctx.Arg(0))
+				}
+				run, args = ctx.Command.Path(), ctx.Args
+				return nil
+			}
+			deploy := r.Command("deploy", "部署服务", handler).Args("<service>", 1).BoolFlag("force", "强制")
+			deploy.Command("rollback", "回滚", handler)
+
+			err := r.Dispatch(&PostReq{Text: Text{Content: tt.text}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if run != tt.wantRun || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("ran %q %q, want %q %q", run, args, tt.wantRun, tt.wantArgs)
+			}
+			if tt.wantReply == "" {
+				if len(replies) != 0 {
+					t.Errorf("replies = %q, want none", replies)
+				}
+				return
+			}
+			if len(replies) != 1 || !strings.Contains(replies[0], tt.wantReply) {
+				t.Errorf("replies = %q, want one containing %q", replies, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestRouterServeHTTPRecoversPanic(t *testing.T) {
+	errs := make(chan error, 1)
+	replies := make(chan *Message, 1)
+	r := NewRouter("Bot")
+	r.ReplyFunc = func(req *PostReq, msg *Message) error {
+		replies <- msg
+		return nil
+	}
+	r.OnError = func(req *PostReq, err error) { errs <- err }
+	r.Command("boom", "", func(ctx *CommandContext) error { panic("boom") })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/robot", strings.NewReader(`{"text":{"content":"boom"}}`)))
+	if w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Fatalf("response = %d %s, want 200 {}", w.Code, w.Body.String())
+	}
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrCommandPanic) || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("err = %v, want ErrCommandPanic", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnError was not called")
+	}
+	select {
+	case msg := <-replies:
+		if strings.Contains(msg.Text, "goroutine") {
+			t.Errorf("reply leaks the stack: %s", msg.Text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+}
@@ -0,0 +1,175 @@
+package ding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// DedupKeyFunc 计算消息的指纹，指纹相同的消息在窗口内只发送一次
+type DedupKeyFunc func(msg *Message) string
+
+var (
+	// DedupByContent 按完整的消息内容去重，包括@的人
+	DedupByContent DedupKeyFunc = func(msg *Message) string {
+		b, _ := json.Marshal(msg)
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	// DedupByTitle 按标题去重，没有标题的text消息按内容去重
+	DedupByTitle DedupKeyFunc = func(msg *Message) string {
+		if msg.Title != "" {
+			return msg.MsgType + ":" + msg.Title
+		}
+		return msg.MsgType + ":" + msg.Text
+	}
+)
+
+// DedupSummaryFunc 生成窗口结束时的汇总消息，suppressed为窗口内被抑制的重复消息数量
+type DedupSummaryFunc func(msg *Message, suppressed int, window time.Duration) *Message
+
+// DefaultDedupSummary 默认的汇总消息，如：最近30m0s内抑制了14条重复消息：CPU使用率过高
+func DefaultDedupSummary(msg *Message, suppressed int, window time.Duration) *Message {
+	subject := msg.Title
+	if subject == "" {
+		subject = msg.Text
+	}
+	if r := []rune(subject); len(r) > 50 {
+		subject = string(r[:50]) + "..."
+	}
+	return NewTextMessage(fmt.Sprintf("最近%s内抑制了%d条重复消息：%s", window, suppressed, subject))
+}
+
+type dedupEntry struct {
+	msg        *Message
+	suppressed int
+	timer      *time.Timer
+	// 发送第一条消息的Sender，汇总消息也发送到这里
+	next Sender
+}
+
+// Deduper 重复消息抑制，放在任意Sender前面。同一指纹的消息在窗口内只发送第一条，
+// 后面的重复消息直接丢弃，Send 返回nil；开启 Summary 后窗口结束时发送一条汇总消息。
+// 用 WithDeduper 安装到客户端后，客户端的所有发送方法都会经过它
+type Deduper struct {
+	next   Sender
+	window time.Duration
+	key    DedupKeyFunc
+
+	// 为true时，窗口内有被抑制的消息则在窗口结束时发送汇总消息
+	Summary bool
+	// 生成汇总消息，为nil时使用 DefaultDedupSummary
+	SummaryFunc DedupSummaryFunc
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+// NewDeduper 创建重复消息抑制，window为窗口大小，key为指纹函数，为nil时使用 DedupByContent。
+// next为nil时只能通过 Deduper.Middleware 或 WithDeduper 使用
+func NewDeduper(next Sender, window time.Duration, key DedupKeyFunc) *Deduper {
+	if key == nil {
+		key = DedupByContent
+	}
+	return &Deduper{
+		next:    next,
+		window:  window,
+		key:     key,
+		entries: map[string]*dedupEntry{},
+	}
+}
+
+// Send 按指纹函数计算指纹后发送，实现 Sender
+func (d *Deduper) Send(msg *Message) error {
+	return d.SendWithKey(d.key(msg), msg)
+}
+
+// SendWithKey 使用调用者指定的指纹发送
+func (d *Deduper) SendWithKey(key string, msg *Message) error {
+	return d.send(d.next, key, msg)
+}
+
+// Middleware 作为发送中间件使用，去重状态保存在Deduper中，每个发送目标单独去重，目标由 TargetKey 区分
+func (d *Deduper) Middleware() Middleware {
+	return func(next Sender) Sender {
+		return SenderFunc(func(msg *Message) error {
+			return d.send(next, TargetKey(next)+"\n"+d.key(msg), msg)
+		})
+	}
+}
+
+func (d *Deduper) send(next Sender, key string, msg *Message) error {
+	d.mu.Lock()
+	if e, ok := d.entries[key]; ok {
+		e.suppressed++
+		d.mu.Unlock()
+		return nil
+	}
+	e := &dedupEntry{msg: msg, next: next}
+	d.entries[key] = e
+	e.timer = time.AfterFunc(d.window, func() { d.expire(key, e) })
+	d.mu.Unlock()
+
+	err := next.Send(msg)
+	if err != nil {
+		// 发送失败的消息不占用窗口，调用者重试时可以再次发送
+		d.mu.Lock()
+		if d.entries[key] == e {
+			e.timer.Stop()
+			delete(d.entries, key)
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// expire 窗口结束，按需发送汇总消息
+func (d *Deduper) expire(key string, e *dedupEntry) {
+	d.mu.Lock()
+	if d.entries[key] != e {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.entries, key)
+	suppressed := e.suppressed
+	d.mu.Unlock()
+
+	d.summarize(e, suppressed)
+}
+
+func (d *Deduper) summarize(e *dedupEntry, suppressed int) {
+	if !d.Summary || suppressed == 0 {
+		return
+	}
+	f := d.SummaryFunc
+	if f == nil {
+		f = DefaultDedupSummary
+	}
+	if err := e.next.Send(f(e.msg, suppressed, d.window)); err != nil {
+		log.Printf("send ding dedup summary to %s failed: %s\n", TargetName(e.next), err)
+	}
+}
+
+// Flush 立即结束所有窗口，按需发送汇总消息，一般在程序退出前调用
+func (d *Deduper) Flush() {
+	d.mu.Lock()
+	entries := d.entries
+	d.entries = map[string]*dedupEntry{}
+	d.mu.Unlock()
+
+	for _, e := range entries {
+		e.timer.Stop()
+		d.summarize(e, e.suppressed)
+	}
+}
+
+func (d *Deduper) String() string {
+	if d.next == nil {
+		return "dedup"
+	}
+	return "dedup:" + TargetName(d.next)
+}
@@ -0,0 +1,146 @@
+package ding_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+func TestDeduperTargets(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	for _, token := range []string{"abcdef-1", "abcdef-2", "session-1", "session-2"} {
+		srv.AddRobot(token, "")
+	}
+	d := ding.NewDeduper(nil, time.Minute, nil)
+	newClient := func(token string) *ding.WhClient {
+		c, err := srv.NewWhClient(token, "", ding.WithDeduper(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	session := func(token string) *ding.WhClient {
+		return ding.NewWhClientUseSessionWebhook(srv.WebhookUrl()+"?access_token="+token, ding.WithDeduper(d))
+	}
+
+	tests := []struct {
+		name    string
+		clients []*ding.WhClient
+		want    int
+	}{
+		{name: "same client", clients: []*ding.WhClient{newClient("abcdef-1"), newClient("abcdef-1")}, want: 1},
+		{name: "tokens with the same prefix", clients: []*ding.WhClient{newClient("abcdef-1"), newClient("abcdef-2")}, want: 2},
+		{name: "different session webhooks", clients: []*ding.WhClient{session("session-1"), session("session-2")}, want: 2},
+		{name: "same session webhook", clients: []*ding.WhClient{session("session-1"), session("session-1")}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			d.Flush()
+			if tt.clients[0].String() != tt.clients[1].String() {
+				t.Fatalf("names %q and %q differ, the test needs colliding names", tt.clients[0], tt.clients[1])
+			}
+			for _, c := range tt.clients {
+				if err := c.Send(ding.NewTextMessage("deploy done")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := len(srv.Messages()); n != tt.want {
+				t.Errorf("delivered %d messages, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeduperWindow(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		summary  bool
+		sends    []string
+		fault    bool
+		wait     time.Duration
+		then     []string
+		want     []string
+		wantErrs int
+	}{
+		{name: "suppressed in window", sends: []string{"a", "a", "b", "a"}, want: []string{"a", "b"}},
+		{name: "sent again after window", sends: []string{"a", "a"}, wait: 150 * time.Millisecond, then: []string{"a"}, want: []string{"a", "a"}},
+		{name: "summary after window", summary: true, sends: []string{"a", "a", "a"}, wait: 150 * time.Millisecond, want: []string{"a", "抑制了2条重复消息：a"}},
+		{name: "no summary without duplicates", summary: true, sends: []string{"a"}, wait: 150 * time.Millisecond, want: []string{"a"}},
+		{name: "failed send does not hold the window", sends: []string{"a"}, fault: true, then: []string{"a"}, want: []string{"a"}, wantErrs: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			d := ding.NewDeduper(c, 50*time.Millisecond, nil)
+			d.Summary = tt.summary
+			if tt.fault {
+				srv.InjectFault(dingtest.EndpointWebhook, 1, dingtest.Fault{Err: &ding.Error{ErrCode: 300001, ErrMsg: "bad"}})
+			}
+			var errs int
+			send := func(texts []string) {
+				for _, text := range texts {
+					if err := d.Send(ding.NewTextMessage(text)); err != nil {
+						errs++
+					}
+				}
+			}
+			send(tt.sends)
+			time.Sleep(tt.wait)
+			send(tt.then)
+			if errs != tt.wantErrs {
+				t.Errorf("%d sends failed, want %d", errs, tt.wantErrs)
+			}
+			msgs := srv.Messages()
+			if len(msgs) != len(tt.want) {
+				t.Fatalf("delivered %d messages, want %d", len(msgs), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(msgs[i].Text, want) {
+					t.Errorf("message %d = %q, want %q", i, msgs[i].Text, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeduperConcurrent(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	d := ding.NewDeduper(nil, time.Minute, nil)
+	d.Summary = true
+	c, err := srv.NewWhClient("token", "", ding.WithDeduper(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.SendTextMsg("disk full")
+		}()
+	}
+	wg.Wait()
+	d.Flush()
+
+	msgs := srv.Messages()
+	if len(msgs) != 2 || !strings.Contains(msgs[1].Text, "抑制了19条重复消息") {
+		t.Errorf("delivered %d messages, want the first one and a summary of 19", len(msgs))
+	}
+}
@@ -0,0 +1,202 @@
+package ding
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultDigestTitle 汇总消息的标题，%d为消息数量
+	DefaultDigestTitle = "消息汇总（%d条）"
+	// ErrDigestClosed 汇总发送器已经关闭
+	ErrDigestClosed = errors.New("ding: digester is closed")
+)
+
+// Digester 汇总发送，收集同一个目标在窗口时间内或达到数量上限的消息，合并成一条markdown消息发送，
+// link消息可以合并成一条feedCard消息，@的人取所有消息的并集。用于部署等消息集中的场景，避免触发机器人限流。
+// 每个目标创建一个 Digester
+type Digester struct {
+	next     Sender
+	window   time.Duration
+	maxCount int
+
+	// 为true时link消息合并成feedCard，只有webhook方式支持feedCard，NewDigester 时根据next自动设置
+	LinksAsFeedCard bool
+	// 汇总后发送失败时的回调，为nil时记录日志
+	OnError func(msgs []*Message, err error)
+
+	mu     sync.Mutex
+	msgs   []*Message
+	timer  *time.Timer
+	closed bool
+}
+
+// NewDigester 创建汇总发送器，window为收集的时间窗口，从第一条消息开始计算；maxCount为一次最多汇总的消息数，<=0表示不限制
+func NewDigester(next Sender, window time.Duration, maxCount int) *Digester {
+	_, isWebhook := next.(*WhClient)
+	return &Digester{
+		next:            next,
+		window:          window,
+		maxCount:        maxCount,
+		LinksAsFeedCard: isWebhook,
+	}
+}
+
+// Send 把消息加入当前批次，实现 Sender。批次达到maxCount时在调用者的goroutine中发送汇总消息，
+// 会阻塞到发送完成；其他情况立即返回。汇总消息发送失败时调用 OnError，不通过返回值返回
+func (d *Digester) Send(msg *Message) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrDigestClosed
+	}
+	d.msgs = append(d.msgs, msg)
+	if d.maxCount > 0 && len(d.msgs) >= d.maxCount {
+		msgs := d.take()
+		d.mu.Unlock()
+		d.send(msgs)
+		return nil
+	}
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.window, d.Flush)
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// take 取出当前批次，调用时需要持有锁
+func (d *Digester) take() []*Message {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	msgs := d.msgs
+	d.msgs = nil
+	return msgs
+}
+
+// Flush 立即发送当前批次
+func (d *Digester) Flush() {
+	d.mu.Lock()
+	msgs := d.take()
+	d.mu.Unlock()
+	d.send(msgs)
+}
+
+// Close 发送当前批次，之后不再接收新消息
+func (d *Digester) Close() {
+	d.mu.Lock()
+	d.closed = true
+	msgs := d.take()
+	d.mu.Unlock()
+	d.send(msgs)
+}
+
+func (d *Digester) send(msgs []*Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	for _, m := range Digest(msgs, d.LinksAsFeedCard) {
+		if err := d.next.Send(m); err != nil {
+			if d.OnError != nil {
+				d.OnError(msgs, err)
+			} else {
+				log.Printf("send ding digest of %d messages to %s failed: %s\n", len(msgs), TargetName(d.next), err)
+			}
+		}
+	}
+}
+
+func (d *Digester) String() string {
+	return "digest:" + TargetName(d.next)
+}
+
+// Digest 把多条消息合并：只有一条时原样返回；linksAsFeedCard为true时link消息合并成一条feedCard，
+// 其他消息合并成一条markdown消息，@的人取所有消息的并集
+func Digest(msgs []*Message, linksAsFeedCard bool) []*Message {
+	if len(msgs) <= 1 {
+		return msgs
+	}
+	var links []*Link
+	var others []*Message
+	for _, m := range msgs {
+		if linksAsFeedCard && m.MsgType == WhMsgTypeLink {
+			links = append(links, NewLink(m.Title, m.Text, m.PicUrl, m.MessageUrl))
+		} else {
+			others = append(others, m)
+		}
+	}
+
+	var res []*Message
+	if len(links) > 0 {
+		res = append(res, NewFeedCardMessage(links))
+	}
+	switch len(others) {
+	case 0:
+	case 1:
+		res = append(res, others[0])
+	default:
+		res = append(res, digestMarkdown(others))
+	}
+	return res
+}
+
+// digestMarkdown 合并成一条markdown消息
+func digestMarkdown(msgs []*Message) *Message {
+	parts := make([]string, 0, len(msgs))
+	var at At
+	seen := map[string]bool{}
+	for _, m := range msgs {
+		parts = append(parts, digestPart(m))
+		at.IsAtAll = at.IsAtAll || m.At.IsAtAll
+		for _, id := range m.At.AtUserIds {
+			if !seen["u:"+id] {
+				seen["u:"+id] = true
+				at.AtUserIds = append(at.AtUserIds, id)
+			}
+		}
+		for _, mobile := range m.At.AtMobiles {
+			if !seen["m:"+mobile] {
+				seen["m:"+mobile] = true
+				at.AtMobiles = append(at.AtMobiles, mobile)
+			}
+		}
+	}
+	title := fmt.Sprintf(DefaultDigestTitle, len(msgs))
+	msg := NewMarkdownMessage(title, fmt.Sprintf("### %s\n\n%s", title, strings.Join(parts, "\n\n---\n\n")))
+	msg.At = at
+	return msg
+}
+
+// digestPart 一条消息在汇总中的内容
+func digestPart(m *Message) string {
+	switch m.MsgType {
+	case WhMsgTypeText:
+		return m.Text
+	case WhMsgTypeLink:
+		return fmt.Sprintf("#### [%s](%s)\n\n%s", m.Title, m.MessageUrl, m.Text)
+	case WhMsgTypeActionCard:
+		var btns []string
+		if m.SingleURL != "" {
+			btns = append(btns, fmt.Sprintf("[%s](%s)", m.SingleTitle, m.SingleURL))
+		}
+		for _, b := range m.Btns {
+			btns = append(btns, fmt.Sprintf("[%s](%s)", b.Title, b.ActionURL))
+		}
+		return fmt.Sprintf("#### %s\n\n%s\n\n%s", m.Title, m.Text, strings.Join(btns, " | "))
+	case MsgTypeImage:
+		return fmt.Sprintf("![image](%s)", m.PhotoURL)
+	case WhMsgTypeFeedCard:
+		var links []string
+		for _, l := range m.Links {
+			links = append(links, fmt.Sprintf("- [%s](%s)", l.Title, l.MessageUrl))
+		}
+		return strings.Join(links, "\n")
+	default:
+		return fmt.Sprintf("#### %s\n\n%s", m.Title, m.Text)
+	}
+}
@@ -0,0 +1,183 @@
+package ding_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// newDigestClient 创建发送到假服务的webhook客户端
+func newDigestClient(t *testing.T, srv *dingtest.Server) *ding.WhClient {
+	t.Helper()
+	srv.AddRobot("digest", "")
+	c, err := srv.NewWhClient("digest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDigesterFlushByCount(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	d := ding.NewDigester(newDigestClient(t, srv), time.Hour, 3)
+
+	for i, msg := range []*ding.Message{
+		ding.NewTextMessage("deploy api").WithAtUserIds("u1"),
+		ding.NewTextMessage("deploy web").WithAtUserIds("u1", "u2"),
+	} {
+		if err := d.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(srv.Messages()); n != 0 {
+			t.Fatalf("after %d messages delivered %d, want 0", i+1, n)
+		}
+	}
+	// 第三条达到maxCount，在Send中发送汇总消息
+	if err := d.Send(ding.NewTextMessage("deploy job").WithAtMobiles("138")); err != nil {
+		t.Fatal(err)
+	}
+	msgs := srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.MsgType != ding.WhMsgTypeMarkdown || got.Title != "消息汇总（3条）" {
+		t.Errorf("digest = %s %q, want markdown 消息汇总（3条）", got.MsgType, got.Title)
+	}
+	for _, s := range []string{"deploy api", "deploy web", "deploy job"} {
+		if !strings.Contains(got.Text, s) {
+			t.Errorf("digest text %q does not contain %q", got.Text, s)
+		}
+	}
+	if strings.Join(got.At.AtUserIds, ",") != "u1,u2" || strings.Join(got.At.AtMobiles, ",") != "138" {
+		t.Errorf("digest at = %+v, want union u1,u2 and 138", got.At)
+	}
+}
+
+func TestDigesterFlushByWindow(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	d := ding.NewDigester(newDigestClient(t, srv), 50*time.Millisecond, 0)
+
+	for _, s := range []string{"a", "b"} {
+		if err := d.Send(ding.NewTextMessage(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(srv.Messages()); n != 0 {
+		t.Fatalf("delivered %d messages before the window, want 0", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(srv.Messages()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("digest was not sent after the window")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 窗口从下一条消息重新开始计算
+	if err := d.Send(ding.NewTextMessage("c")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	msgs := srv.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("delivered %d messages, want 2", len(msgs))
+	}
+	// 只有一条时原样发送
+	if msgs[1].MsgType != ding.WhMsgTypeText || msgs[1].Text != "c" {
+		t.Errorf("single message = %s %q, want the original text", msgs[1].MsgType, msgs[1].Text)
+	}
+}
+
+func TestDigesterFlushAndClose(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	d := ding.NewDigester(newDigestClient(t, srv), time.Hour, 0)
+
+	// webhook客户端的link消息合并成feedCard，其他消息单独发送
+	for _, msg := range []*ding.Message{
+		ding.NewLinkMessage("release 1", "notes", "", "https://example.com/1"),
+		ding.NewLinkMessage("release 2", "notes", "", "https://example.com/2"),
+		ding.NewTextMessage("done"),
+	} {
+		if err := d.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Flush()
+	msgs := srv.Messages()
+	if len(msgs) != 2 || msgs[0].MsgType != ding.WhMsgTypeFeedCard || msgs[1].MsgType != ding.WhMsgTypeText {
+		t.Fatalf("delivered %d messages, want a feedCard and a text", len(msgs))
+	}
+	// 没有消息时Flush不发送
+	d.Flush()
+
+	if err := d.Send(ding.NewTextMessage("last")); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if n := len(srv.Messages()); n != 3 {
+		t.Errorf("delivered %d messages after Close, want 3", n)
+	}
+	if err := d.Send(ding.NewTextMessage("late")); !errors.Is(err, ding.ErrDigestClosed) {
+		t.Errorf("Send after Close = %v, want ErrDigestClosed", err)
+	}
+}
+
+func TestDigesterOnError(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	d := ding.NewDigester(newDigestClient(t, srv), time.Hour, 2)
+	var failed []*ding.Message
+	d.OnError = func(msgs []*ding.Message, err error) {
+		failed = msgs
+	}
+	srv.InjectFault(dingtest.EndpointWebhook, 1, dingtest.Fault{Err: &ding.Error{ErrCode: 310000, ErrMsg: "keywords not in content"}})
+
+	for _, s := range []string{"a", "b"} {
+		if err := d.Send(ding.NewTextMessage(s)); err != nil {
+			t.Fatalf("Send returned %v, errors go to OnError", err)
+		}
+	}
+	if len(failed) != 2 {
+		t.Errorf("OnError got %d messages, want 2", len(failed))
+	}
+}
+
+func TestDigesterConcurrent(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	// 机器人每分钟最多20条，汇总后100条消息只发送10次
+	srv.SetRateLimit(20, time.Minute)
+	d := ding.NewDigester(newDigestClient(t, srv), time.Hour, 10)
+	d.OnError = func(msgs []*ding.Message, err error) { t.Errorf("send digest: %v", err) }
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.Send(ding.NewTextMessage("event " + strconv.Itoa(i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	d.Close()
+	msgs := srv.Messages()
+	if len(msgs) != 10 {
+		t.Fatalf("delivered %d messages, want 10", len(msgs))
+	}
+	for _, m := range msgs {
+		if m.Title != "消息汇总（10条）" {
+			t.Errorf("digest title = %q", m.Title)
+		}
+	}
+}
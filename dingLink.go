@@ -0,0 +1,145 @@
+// 钉钉的统一跳转协议，控制消息中的链接在PC端侧边栏、外部浏览器、工作台应用或者小程序中打开
+// 参考： https://open.dingtalk.com/document/app/message-link-description
+
+package ding
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	// DingLinkPageUrl 在侧边栏或者浏览器打开网页的跳转地址
+	DingLinkPageUrl = "dingtalk://dingtalkclient/page/link"
+	// DingLinkOpenAppUrl 在工作台应用中打开网页的跳转地址
+	DingLinkOpenAppUrl = "dingtalk://dingtalkclient/action/openapp"
+	// DingLinkMiniAppUrl 打开小程序的跳转地址
+	DingLinkMiniAppUrl = "dingtalk://dingtalkclient/action/open_mini_app"
+)
+
+// isDingLink 是否已经是钉钉的跳转协议
+func isDingLink(link string) bool {
+	return strings.HasPrefix(link, "dingtalk://")
+}
+
+// SidebarLink PC端在侧边栏打开target，移动端在钉钉内打开
+func SidebarLink(target string) string {
+	return pageLink(target, true)
+}
+
+// BrowserLink PC端在外部浏览器打开target，移动端在钉钉内打开
+func BrowserLink(target string) string {
+	return pageLink(target, false)
+}
+
+func pageLink(target string, slide bool) string {
+	if target == "" || isDingLink(target) {
+		return target
+	}
+	q := url.Values{}
+	q.Set("url", target)
+	q.Set("pc_slide", strconv.FormatBool(slide))
+	return DingLinkPageUrl + "?" + q.Encode()
+}
+
+// InAppLink 在企业内部应用中打开target，corpId为企业id，agentId为应用的agentId
+func InAppLink(corpId string, agentId int64, target string) string {
+	if target == "" || isDingLink(target) {
+		return target
+	}
+	q := url.Values{}
+	q.Set("corpid", corpId)
+	q.Set("container_type", "work_platform")
+	q.Set("app_id", "0_"+strconv.FormatInt(agentId, 10))
+	q.Set("redirect_type", "jump")
+	q.Set("redirect_url", target)
+	return DingLinkOpenAppUrl + "?" + q.Encode()
+}
+
+// MiniAppLink 打开小程序miniAppId，page为小程序的页面路径，可以带参数，为空时打开首页
+func MiniAppLink(miniAppId, page string) string {
+	q := url.Values{}
+	q.Set("miniAppId", miniAppId)
+	if page != "" {
+		q.Set("page", page)
+	}
+	return DingLinkMiniAppUrl + "?" + q.Encode()
+}
+
+// RewriteLinks 用fn改写消息中所有的跳转链接：link消息、整体跳转actionCard、独立跳转actionCard的按钮、feedCard，
+// 如 msg.RewriteLinks(ding.SidebarLink)。按钮和链接会复制一份，不修改传入的 Btn 和 Link
+func (m *Message) RewriteLinks(fn func(string) string) *Message {
+	m.MessageUrl = rewriteLink(m.MessageUrl, fn)
+	m.SingleURL = rewriteLink(m.SingleURL, fn)
+	m.Btns = rewriteBtns(m.Btns, fn)
+	m.Links = rewriteLinks(m.Links, fn)
+	return m
+}
+
+// OpenInSidebar 消息中的链接在PC端侧边栏打开
+func (m *Message) OpenInSidebar() *Message {
+	return m.RewriteLinks(SidebarLink)
+}
+
+// OpenInBrowser 消息中的链接在PC端外部浏览器打开
+func (m *Message) OpenInBrowser() *Message {
+	return m.RewriteLinks(BrowserLink)
+}
+
+// RewriteLinks 用fn改写消息的跳转链接
+func (m *WhLinkMsg) RewriteLinks(fn func(string) string) *WhLinkMsg {
+	m.Link.MessageUrl = rewriteLink(m.Link.MessageUrl, fn)
+	return m
+}
+
+// RewriteLinks 用fn改写按钮的跳转链接
+func (m *WhEntiretyActionCardMsg) RewriteLinks(fn func(string) string) *WhEntiretyActionCardMsg {
+	m.ActionCard.SingleURL = rewriteLink(m.ActionCard.SingleURL, fn)
+	return m
+}
+
+// RewriteLinks 用fn改写所有按钮的跳转链接
+func (m *WhIndependentActionCardMsg) RewriteLinks(fn func(string) string) *WhIndependentActionCardMsg {
+	m.ActionCard.Btns = rewriteBtns(m.ActionCard.Btns, fn)
+	return m
+}
+
+// RewriteLinks 用fn改写所有链接
+func (m *WhFeedCardMsg) RewriteLinks(fn func(string) string) *WhFeedCardMsg {
+	m.FeedCard.Links = rewriteLinks(m.FeedCard.Links, fn)
+	return m
+}
+
+func rewriteLink(link string, fn func(string) string) string {
+	if link == "" {
+		return link
+	}
+	return fn(link)
+}
+
+func rewriteBtns(btns []*Btn, fn func(string) string) []*Btn {
+	if btns == nil {
+		return nil
+	}
+	res := make([]*Btn, len(btns))
+	for i, b := range btns {
+		nb := *b
+		nb.ActionURL = rewriteLink(b.ActionURL, fn)
+		res[i] = &nb
+	}
+	return res
+}
+
+func rewriteLinks(links []*Link, fn func(string) string) []*Link {
+	if links == nil {
+		return nil
+	}
+	res := make([]*Link, len(links))
+	for i, l := range links {
+		nl := *l
+		nl.MessageUrl = rewriteLink(l.MessageUrl, fn)
+		res[i] = &nl
+	}
+	return res
+}
@@ -0,0 +1,441 @@
+// Package dingtest 基于 httptest 的钉钉假服务，实现webhook、获取accessToken、批量发送单聊和发送群聊消息的接口，
+// 校验加签和accessToken，记录收到的消息，可以注入错误和限流。用于单元测试，不用请求真正的钉钉。
+//
+//	srv := dingtest.NewServer()
+//	defer srv.Close()
+//	srv.AddRobot("token", "SEC...")
+//	c, err := srv.NewWhClient("token", "SEC...")
+
+package dingtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wanghkkk/ding"
+)
+
+// 假服务的接口，用于 Messages、InjectFault
+var (
+	EndpointWebhook     = "webhook"
+	EndpointAccessToken = "accessToken"
+	EndpointOtO         = "oto"
+	EndpointGroup       = "group"
+)
+
+// 各接口的路径，和钉钉的一致
+var (
+	WebhookPath     = "/robot/send"
+	AccessTokenPath = "/v1.0/oauth2/accessToken"
+	OtOPath         = "/v1.0/robot/oToMessages/batchSend"
+	GroupPath       = "/v1.0/robot/groupMessages/send"
+)
+
+var (
+	// DefaultTokenExpireIn 发放的accessToken的有效期，单位秒
+	DefaultTokenExpireIn int64 = 7200
+	// MaxSignSkew 加签的timestamp和当前时间最多相差多久，和钉钉一样为1小时
+	MaxSignSkew = time.Hour
+)
+
+// Fault 注入的错误
+type Fault struct {
+	// HTTP状态码，为0时为200，webhook的错误一般是200加errcode
+	StatusCode int
+	// 回复的错误，为nil时只回复状态码
+	Err *ding.Error
+	// 回复前等待的时间，用于测试超时
+	Delay time.Duration
+}
+
+// Server 钉钉假服务
+type Server struct {
+	*httptest.Server
+
+	mux *http.ServeMux
+
+	// 发放的accessToken的有效期，单位秒，为0时使用 DefaultTokenExpireIn
+	TokenExpireIn int64
+
+	mu       sync.Mutex
+	robots   map[string]string
+	apps     map[string]string
+	tokens   map[string]string
+	tokenSeq int
+	received []*Received
+	faults   map[string][]Fault
+
+	rateLimit    int
+	rateInterval time.Duration
+	sent         map[string][]time.Time
+}
+
+// NewServer 启动钉钉假服务，用完需要 Close
+func NewServer() *Server {
+	s := &Server{
+		robots: map[string]string{},
+		apps:   map[string]string{},
+		tokens: map[string]string{},
+		faults: map[string][]Fault{},
+		sent:   map[string][]time.Time{},
+	}
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc(WebhookPath, s.handleWebhook)
+	s.mux.HandleFunc(AccessTokenPath, s.handleAccessToken)
+	s.mux.HandleFunc(OtOPath, s.handleInterface(EndpointOtO))
+	s.mux.HandleFunc(GroupPath, s.handleInterface(EndpointGroup))
+	s.Server = httptest.NewServer(s.mux)
+	return s
+}
+
+// WebhookUrl webhook地址，不含access_token，可以作为 WhClient.BaseUrl
+func (s *Server) WebhookUrl() string {
+	return s.URL + WebhookPath
+}
+
+// AccessTokenUrl 获取accessToken的地址
+func (s *Server) AccessTokenUrl() string {
+	return s.URL + AccessTokenPath
+}
+
+// OtOUrl 批量发送单聊消息的地址
+func (s *Server) OtOUrl() string {
+	return s.URL + OtOPath
+}
+
+// GroupUrl 发送群聊消息的地址
+func (s *Server) GroupUrl() string {
+	return s.URL + GroupPath
+}
+
+// Install 把ding包的钉钉地址替换成假服务的地址并清空accessToken缓存，返回恢复原地址的函数。
+// 接口方式的客户端在创建时读取地址，需要在 Install 之后创建。修改的是包级变量，使用 Install 的测试不能并行，
+// 用 NewWhClient、NewOtOClient、NewGroupClient 创建的客户端不需要 Install，可以并行
+func (s *Server) Install() (restore func()) {
+	webhook, token, oto, group := ding.WebhookBaseUrl, ding.AccessTokenUrl, ding.OtOMessageBatchSendUrl, ding.GroupMessageSendUrl
+	ding.WebhookBaseUrl = s.WebhookUrl()
+	ding.AccessTokenUrl = s.AccessTokenUrl()
+	ding.OtOMessageBatchSendUrl = s.OtOUrl()
+	ding.GroupMessageSendUrl = s.GroupUrl()
+	_ = ding.ResetTokenCache()
+	return func() {
+		ding.WebhookBaseUrl, ding.AccessTokenUrl, ding.OtOMessageBatchSendUrl, ding.GroupMessageSendUrl = webhook, token, oto, group
+		_ = ding.ResetTokenCache()
+	}
+}
+
+// NewWhClient 创建发送到假服务的webhook客户端，不需要 Install
+func (s *Server) NewWhClient(accessToken, secret string, opts ...ding.Option) (*ding.WhClient, error) {
+	return ding.NewWhClientWithOptions(accessToken, secret, append([]ding.Option{ding.WithEndpoint(s.WebhookUrl())}, opts...)...)
+}
+
+// NewOtOClient 创建发送到假服务的单聊客户端，使用单独的accessToken缓存，不需要 Install
+func (s *Server) NewOtOClient(robotCode, appKey, appSecret string, opts ...ding.Option) (*ding.OtOClient, error) {
+	return ding.NewOtOClientWithOptions(robotCode, appKey, appSecret, s.options(s.OtOUrl(), opts)...)
+}
+
+// NewGroupClient 创建发送到假服务的群聊客户端，使用单独的accessToken缓存，不需要 Install
+func (s *Server) NewGroupClient(robotCode, appKey, appSecret string, opts ...ding.Option) (*ding.GroupClient, error) {
+	return ding.NewGroupClientWithOptions(robotCode, appKey, appSecret, s.options(s.GroupUrl(), opts)...)
+}
+
+// Options 让接口方式的客户端请求假服务的选项：新版和旧版接口的地址、获取accessToken的地址都指向假服务，
+// 如 ding.NewWorkNoticeClient(agentId, appKey, appSecret, srv.Options()...)。
+// 假服务没有实现的接口可以用 Handle 添加
+func (s *Server) Options() []ding.Option {
+	return []ding.Option{ding.WithBaseUrl(s.URL, s.URL), ding.WithAccessTokenUrl(s.AccessTokenUrl())}
+}
+
+// options 假服务的地址在前，调用者的选项可以覆盖
+func (s *Server) options(endpoint string, opts []ding.Option) []ding.Option {
+	return append(append(s.Options(), ding.WithEndpoint(endpoint)), opts...)
+}
+
+// Handle 添加假服务没有实现的接口，pattern为接口路径，如 ding.UserGetByMobilePath。
+// handler中可以用 AppKey 校验accessToken
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AppKey 校验请求中的accessToken（新版接口的请求头或旧版接口的access_token参数），返回发放给哪个应用
+func (s *Server) AppKey(r *http.Request) (string, bool) {
+	token := r.Header.Get("x-acs-dingtalk-access-token")
+	if token == "" {
+		token = r.URL.Query().Get("access_token")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	appKey, ok := s.tokens[token]
+	return appKey, ok
+}
+
+// AddRobot 添加webhook机器人，secret为空时不校验加签
+func (s *Server) AddRobot(accessToken, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.robots[accessToken] = secret
+}
+
+// AddApp 添加企业内部应用，可以用appKey和appSecret获取accessToken
+func (s *Server) AddApp(appKey, appSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[appKey] = appSecret
+}
+
+// RevokeTokens 让已发放的accessToken全部失效，用于测试accessToken过期
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]string{}
+}
+
+// InjectFault 接下来times次请求endpoint时回复错误，错误按注入的顺序回复
+func (s *Server) InjectFault(endpoint string, times int, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < times; i++ {
+		s.faults[endpoint] = append(s.faults[endpoint], f)
+	}
+}
+
+// SetRateLimit 每个webhook机器人、每个应用在interval内最多发送limit条消息，超过的回复限流错误。limit<=0表示不限流
+func (s *Server) SetRateLimit(limit int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit = limit
+	s.rateInterval = interval
+	s.sent = map[string][]time.Time{}
+}
+
+// Messages 收到并成功回复的消息，endpoints为空时返回所有接口的
+func (s *Server) Messages(endpoints ...string) []*Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(endpoints) == 0 {
+		return append([]*Received{}, s.received...)
+	}
+	var res []*Received
+	for _, r := range s.received {
+		for _, e := range endpoints {
+			if r.Endpoint == e {
+				res = append(res, r)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// Reset 清空收到的消息、注入的错误和限流计数，保留机器人、应用和已发放的accessToken
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = nil
+	s.faults = map[string][]Fault{}
+	s.sent = map[string][]time.Time{}
+}
+
+// fault 取出endpoint下一个注入的错误，有错误时已经回复
+func (s *Server) fault(w http.ResponseWriter, endpoint string) bool {
+	s.mu.Lock()
+	fs := s.faults[endpoint]
+	if len(fs) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	f := fs[0]
+	s.faults[endpoint] = fs[1:]
+	s.mu.Unlock()
+
+	if f.Delay > 0 {
+		time.Sleep(f.Delay)
+	}
+	status := f.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if f.Err == nil {
+		w.WriteHeader(status)
+		return true
+	}
+	writeJson(w, status, f.Err)
+	return true
+}
+
+// allow 限流，key为webhook机器人或应用
+func (s *Server) allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rateLimit <= 0 {
+		return true
+	}
+	now := time.Now()
+	var keep []time.Time
+	for _, t := range s.sent[key] {
+		if now.Sub(t) < s.rateInterval {
+			keep = append(keep, t)
+		}
+	}
+	if len(keep) >= s.rateLimit {
+		s.sent[key] = keep
+		return false
+	}
+	s.sent[key] = append(keep, now)
+	return true
+}
+
+func (s *Server) record(r *Received) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Time = time.Now()
+	s.received = append(s.received, r)
+}
+
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.fault(w, EndpointWebhook) {
+		return
+	}
+	q := r.URL.Query()
+	token := q.Get("access_token")
+	s.mu.Lock()
+	secret, ok := s.robots[token]
+	s.mu.Unlock()
+	if !ok {
+		writeJson(w, http.StatusOK, &ding.Error{ErrCode: ding.ErrCodeTokenNotExist, ErrMsg: "token is not exist"})
+		return
+	}
+	if secret != "" {
+		if msg := checkSign(q.Get("timestamp"), q.Get("sign"), secret); msg != "" {
+			writeJson(w, http.StatusOK, &ding.Error{ErrCode: ding.ErrCodeSecurityCheck, ErrMsg: msg})
+			return
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rec, err := parseWebhookBody(body)
+	if err != nil {
+		writeJson(w, http.StatusOK, &ding.Error{ErrCode: 400102, ErrMsg: err.Error()})
+		return
+	}
+	if !s.allow("webhook:" + token) {
+		writeJson(w, http.StatusOK, &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast, exceed 20 times per minute"})
+		return
+	}
+	rec.AccessToken = token
+	s.record(rec)
+	writeJson(w, http.StatusOK, &ding.Error{ErrMsg: "ok"})
+}
+
+// checkSign 校验加签，失败时返回错误信息
+func checkSign(timestamp, sign, secret string) string {
+	if timestamp == "" || sign == "" {
+		return "sign not match"
+	}
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "invalid timestamp"
+	}
+	skew := time.Since(time.UnixMilli(ms))
+	if skew > MaxSignSkew || skew < -MaxSignSkew {
+		return "invalid timestamp"
+	}
+	if ding.GetDingSign(timestamp, secret) != sign {
+		return "sign not match"
+	}
+	return ""
+}
+
+func (s *Server) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.fault(w, EndpointAccessToken) {
+		return
+	}
+	var aks ding.AppKeySecret
+	if err := json.NewDecoder(r.Body).Decode(&aks); err != nil {
+		writeJson(w, http.StatusBadRequest, &ding.Error{Code: "MissingParameter", Message: err.Error()})
+		return
+	}
+	s.mu.Lock()
+	secret, ok := s.apps[aks.AppKey]
+	if !ok || secret != aks.AppSecret {
+		s.mu.Unlock()
+		writeJson(w, http.StatusBadRequest, &ding.Error{Code: "invalidClientIdOrSecret", Message: "无效的clientId或者clientSecret"})
+		return
+	}
+	s.tokenSeq++
+	token := fmt.Sprintf("dingtest-%s-%d", aks.AppKey, s.tokenSeq)
+	s.tokens[token] = aks.AppKey
+	s.mu.Unlock()
+
+	expireIn := s.TokenExpireIn
+	if expireIn == 0 {
+		expireIn = DefaultTokenExpireIn
+	}
+	writeJson(w, http.StatusOK, &ding.AccessToken{Token: token, ExpireIn: expireIn})
+}
+
+func (s *Server) handleInterface(endpoint string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.fault(w, endpoint) {
+			return
+		}
+		token := r.Header.Get("x-acs-dingtalk-access-token")
+		appKey, ok := s.AppKey(r)
+		if !ok {
+			writeJson(w, http.StatusUnauthorized, &ding.Error{Code: "InvalidAuthentication", Message: "不合法的access_token"})
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec, err := parseInterfaceBody(endpoint, body)
+		if err != nil {
+			writeJson(w, http.StatusBadRequest, &ding.Error{Code: "InvalidParameter", Message: err.Error()})
+			return
+		}
+		if !s.allow("app:" + appKey) {
+			writeJson(w, http.StatusForbidden, &ding.Error{Code: "Forbidden.AccessDenied.QpsLimitForAppkeyAndApi", Message: "调用该接口超过限流"})
+			return
+		}
+		rec.AccessToken = token
+		rec.AppKey = appKey
+		s.record(rec)
+		resp := map[string]any{"processQueryKey": fmt.Sprintf("dingtest-%d", time.Now().UnixNano())}
+		if endpoint == EndpointOtO {
+			resp["invalidStaffIdList"] = []string{}
+			resp["flowControlledStaffIdList"] = []string{}
+		}
+		writeJson(w, http.StatusOK, resp)
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", ding.ContentTypeJson)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,278 @@
+package dingtest_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// errCode 取出钉钉的错误码，不是 *ding.Error 时返回-1
+func errCode(err error) int {
+	var de *ding.Error
+	if !errors.As(err, &de) {
+		return -1
+	}
+	return de.ErrCode
+}
+
+func TestWebhook(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("signed", "SECsigned")
+	srv.AddRobot("plain", "")
+
+	tests := []struct {
+		name        string
+		token       string
+		secret      string
+		send        func(c *ding.WhClient) error
+		wantErrCode int
+		want        *dingtest.Received
+	}{
+		{
+			name:   "text with sign",
+			token:  "signed",
+			secret: "SECsigned",
+			send: func(c *ding.WhClient) error {
+				return c.SendTextMsgWithUserIds("hello", []string{"u1"})
+			},
+			want: &dingtest.Received{MsgType: "text", Text: "hello", At: ding.At{AtUserIds: []string{"u1"}}},
+		},
+		{
+			name:  "markdown without sign",
+			token: "plain",
+			send: func(c *ding.WhClient) error {
+				return c.SendMarkdownMsg("title", "### body")
+			},
+			want: &dingtest.Received{MsgType: "markdown", Title: "title", Text: "### body"},
+		},
+		{
+			name:   "wrong secret",
+			token:  "signed",
+			secret: "SECwrong",
+			send: func(c *ding.WhClient) error {
+				return c.SendTextMsg("hello")
+			},
+			wantErrCode: ding.ErrCodeSecurityCheck,
+		},
+		{
+			name:   "missing sign",
+			token:  "signed",
+			secret: "",
+			send: func(c *ding.WhClient) error {
+				return c.SendTextMsg("hello")
+			},
+			wantErrCode: ding.ErrCodeSecurityCheck,
+		},
+		{
+			name:  "unknown token",
+			token: "unknown",
+			send: func(c *ding.WhClient) error {
+				return c.SendTextMsg("hello")
+			},
+			wantErrCode: ding.ErrCodeTokenNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			c, err := srv.NewWhClient(tt.token, tt.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.send(c)
+			msgs := srv.Messages(dingtest.EndpointWebhook)
+			if tt.wantErrCode != 0 {
+				if got := errCode(err); got != tt.wantErrCode {
+					t.Fatalf("errcode = %d (err %v), want %d", got, err, tt.wantErrCode)
+				}
+				if len(msgs) != 0 {
+					t.Fatalf("recorded %d messages, want 0", len(msgs))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("recorded %d messages, want 1", len(msgs))
+			}
+			got := msgs[0]
+			if got.AccessToken != tt.token || got.MsgType != tt.want.MsgType || got.Title != tt.want.Title || got.Text != tt.want.Text {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if len(got.At.AtUserIds) != len(tt.want.At.AtUserIds) {
+				t.Errorf("at = %+v, want %+v", got.At, tt.want.At)
+			}
+		})
+	}
+}
+
+func TestInjectFault(t *testing.T) {
+	tests := []struct {
+		name        string
+		fault       dingtest.Fault
+		times       int
+		retries     int
+		wantErr     bool
+		wantLimited bool
+		wantCount   int
+	}{
+		{
+			name:        "rate limited without retry",
+			fault:       dingtest.Fault{Err: &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast"}},
+			times:       1,
+			wantErr:     true,
+			wantLimited: true,
+		},
+		{
+			name:      "rate limited then retried",
+			fault:     dingtest.Fault{Err: &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast"}},
+			times:     2,
+			retries:   2,
+			wantCount: 1,
+		},
+		{
+			name:    "server error is not retried",
+			fault:   dingtest.Fault{StatusCode: http.StatusInternalServerError},
+			times:   1,
+			retries: 2,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := dingtest.NewServer()
+			defer srv.Close()
+			srv.AddRobot("token", "")
+			c, err := srv.NewWhClient("token", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.SetRetry(tt.retries, time.Millisecond)
+			srv.InjectFault(dingtest.EndpointWebhook, tt.times, tt.fault)
+
+			err = c.SendTextMsg("hello")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			var de *ding.Error
+			if limited := errors.As(err, &de) && de.IsRateLimited(); limited != tt.wantLimited {
+				t.Errorf("rate limited = %v, want %v", limited, tt.wantLimited)
+			}
+			if got := len(srv.Messages()); got != tt.wantCount {
+				t.Errorf("recorded %d messages, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestInterface(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddApp("appKey", "appSecret")
+
+	oto, err := srv.NewOtOClient("robot", "appKey", "appSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := srv.NewGroupClient("robot", "appKey", "appSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	badOtO, err := srv.NewOtOClient("robot", "appKey", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		send     func() error
+		wantErr  bool
+		endpoint string
+		check    func(t *testing.T, r *dingtest.Received)
+	}{
+		{
+			name:     "oto text",
+			send:     func() error { return oto.SendTextMsgWithUserIds("hello", []string{"u1", "u2"}) },
+			endpoint: dingtest.EndpointOtO,
+			check: func(t *testing.T, r *dingtest.Received) {
+				if r.MsgType != ding.IMsgKeyText || r.Text != "hello" || len(r.UserIds) != 2 || r.AppKey != "appKey" || r.RobotCode != "robot" {
+					t.Errorf("got %+v", r)
+				}
+			},
+		},
+		{
+			name:     "group markdown",
+			send:     func() error { return group.SendMarkdownMsg("title", "body", "cid1") },
+			endpoint: dingtest.EndpointGroup,
+			check: func(t *testing.T, r *dingtest.Received) {
+				if r.MsgType != ding.IMsgKeyMarkdown || r.Title != "title" || r.Text != "body" || r.OpenConversationId != "cid1" {
+					t.Errorf("got %+v", r)
+				}
+			},
+		},
+		{
+			name:    "wrong app secret",
+			send:    func() error { return badOtO.SendTextMsgWithUserIds("hello", []string{"u1"}) },
+			wantErr: true,
+		},
+		{
+			name: "injected error",
+			send: func() error {
+				srv.InjectFault(dingtest.EndpointGroup, 1, dingtest.Fault{
+					StatusCode: http.StatusBadRequest,
+					Err:        &ding.Error{Code: "InvalidParameter", Message: "bad"},
+				})
+				return group.SendTextMsg("hello", "cid1")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			err := tt.send()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if n := len(srv.Messages()); n != 0 {
+					t.Fatalf("recorded %d messages, want 0", n)
+				}
+				return
+			}
+			msgs := srv.Messages(tt.endpoint)
+			if len(msgs) != 1 {
+				t.Fatalf("recorded %d messages on %s, want 1", len(msgs), tt.endpoint)
+			}
+			tt.check(t, msgs[0])
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	srv.SetRateLimit(2, time.Minute)
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, wantLimited := range []bool{false, false, true} {
+		err := c.SendTextMsg("hello")
+		var de *ding.Error
+		if limited := errors.As(err, &de) && de.IsRateLimited(); limited != wantLimited {
+			t.Fatalf("send %d: err = %v, want rate limited %v", i, err, wantLimited)
+		}
+	}
+	if n := len(srv.Messages()); n != 2 {
+		t.Errorf("recorded %d messages, want 2", n)
+	}
+}
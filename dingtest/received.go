@@ -0,0 +1,116 @@
+package dingtest
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/wanghkkk/ding"
+)
+
+// Received 假服务收到的一条消息
+type Received struct {
+	// 接口：EndpointWebhook、EndpointOtO、EndpointGroup
+	Endpoint string
+	// webhook的access_token或接口的accessToken
+	AccessToken string
+	// 接口方式获取accessToken的应用
+	AppKey string
+	// webhook的msgtype，如 text；接口方式为msgKey，如 sampleText
+	MsgType string
+	// 标题，text消息没有标题
+	Title string
+	// 消息内容：text的content，其他消息的text，图片消息的photoURL
+	Text string
+	// webhook方式@的人
+	At ding.At
+	// 接口方式的robotCode、msgParam
+	RobotCode string
+	MsgParam  string
+	// 单聊的用户
+	UserIds []string
+	// 群聊的群id
+	OpenConversationId string
+	// 原始的请求体
+	Body []byte
+	// 收到的时间
+	Time time.Time
+}
+
+// content 各种消息共有的字段
+type content struct {
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+	Content  string `json:"content"`
+	PhotoURL string `json:"photoURL"`
+}
+
+func (c *content) text() string {
+	switch {
+	case c.Content != "":
+		return c.Content
+	case c.Text != "":
+		return c.Text
+	default:
+		return c.PhotoURL
+	}
+}
+
+func parseWebhookBody(body []byte) (*Received, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	var msgType string
+	if err := json.Unmarshal(m["msgtype"], &msgType); err != nil || msgType == "" {
+		return nil, errors.New("param error: msgtype is required")
+	}
+	rec := &Received{Endpoint: EndpointWebhook, MsgType: msgType, Body: body}
+	var c content
+	if raw, ok := m[msgType]; ok {
+		_ = json.Unmarshal(raw, &c)
+	}
+	rec.Title, rec.Text = c.Title, c.text()
+	if raw, ok := m["at"]; ok {
+		_ = json.Unmarshal(raw, &rec.At)
+	}
+	return rec, nil
+}
+
+func parseInterfaceBody(endpoint string, body []byte) (*Received, error) {
+	var b struct {
+		RobotCode          string   `json:"robotCode"`
+		MsgKey             string   `json:"msgKey"`
+		MsgParam           string   `json:"msgParam"`
+		UserIds            []string `json:"userIds"`
+		OpenConversationId string   `json:"openConversationId"`
+	}
+	if err := json.Unmarshal(body, &b); err != nil {
+		return nil, err
+	}
+	switch {
+	case b.RobotCode == "":
+		return nil, errors.New("robotCode is mandatory")
+	case b.MsgKey == "":
+		return nil, errors.New("msgKey is mandatory")
+	case endpoint == EndpointOtO && len(b.UserIds) == 0:
+		return nil, errors.New("userIds is mandatory")
+	case endpoint == EndpointGroup && b.OpenConversationId == "":
+		return nil, errors.New("openConversationId is mandatory")
+	}
+	var c content
+	if err := json.Unmarshal([]byte(b.MsgParam), &c); err != nil {
+		return nil, errors.New("msgParam is not valid json")
+	}
+	return &Received{
+		Endpoint:           endpoint,
+		MsgType:            b.MsgKey,
+		Title:              c.Title,
+		Text:               c.text(),
+		RobotCode:          b.RobotCode,
+		MsgParam:           b.MsgParam,
+		UserIds:            b.UserIds,
+		OpenConversationId: b.OpenConversationId,
+		Body:               body,
+	}, nil
+}
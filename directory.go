@@ -0,0 +1,362 @@
+// 通讯录，根据手机号、邮箱查找用户的userId，需要企业内部应用有通讯录的读权限
+// 参考： https://open.dingtalk.com/document/orgapp/query-users-by-phone-number
+// https://open.dingtalk.com/document/orgapp/query-user-details
+// https://open.dingtalk.com/document/orgapp/queries-the-complete-information-of-a-department-user
+
+package ding
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 通讯录接口的路径，地址为客户端的旧版接口地址加上路径，见 WithBaseUrl
+const (
+	// UserGetByMobilePath 根据手机号查询用户
+	UserGetByMobilePath = "/topapi/v2/user/getbymobile"
+	// UserGetPath 查询用户详情
+	UserGetPath = "/topapi/v2/user/get"
+	// UserListPath 获取部门用户详情
+	UserListPath = "/topapi/v2/user/list"
+	// DepartmentListSubPath 获取子部门列表
+	DepartmentListSubPath = "/topapi/v2/department/listsub"
+)
+
+var (
+	// DefaultDirectoryTTL 通讯录缓存默认的有效期
+	DefaultDirectoryTTL = 10 * time.Minute
+	// RootDeptId 根部门id
+	RootDeptId int64 = 1
+
+	// ErrUserNotFound 通讯录中没有找到用户
+	ErrUserNotFound = errors.New("ding: user not found")
+
+	mobileRe = regexp.MustCompile(`^(\+?86)?(1\d{10})$`)
+)
+
+// User 通讯录中的用户
+type User struct {
+	UserId     string  `json:"userid"`
+	UnionId    string  `json:"unionid"`
+	Name       string  `json:"name"`
+	Mobile     string  `json:"mobile"`
+	Email      string  `json:"email"`
+	OrgEmail   string  `json:"org_email"`
+	Title      string  `json:"title"`
+	JobNumber  string  `json:"job_number"`
+	DeptIdList []int64 `json:"dept_id_list"`
+	Active     bool    `json:"active"`
+}
+
+// Department 部门
+type Department struct {
+	DeptId   int64  `json:"dept_id"`
+	Name     string `json:"name"`
+	ParentId int64  `json:"parent_id"`
+}
+
+type dirEntry struct {
+	value  any
+	expire time.Time
+}
+
+// Directory 通讯录，查询结果缓存TTL时间。用户的标识可以是userId、手机号或邮箱，见 UserRef，
+// ResolveUserIds 统一转换成userId，用于 OtOClient 的接收人和webhook消息的@
+type Directory struct {
+	client *IClient
+
+	// 缓存的有效期，<=0时使用 DefaultDirectoryTTL
+	TTL time.Duration
+	// 按邮箱查找时遍历这些部门及其子部门的用户建立索引，为空时从根部门开始。钉钉没有按邮箱查询用户的接口
+	EmailDeptIds []int64
+
+	mu          sync.Mutex
+	cache       map[string]dirEntry
+	emailIndex  map[string]string
+	emailExpire time.Time
+}
+
+// NewDirectory 创建通讯录，使用c的应用凭证和accessToken，如 NewDirectory(otoClient.IClient)
+func NewDirectory(c *IClient) *Directory {
+	return &Directory{client: c, cache: map[string]dirEntry{}}
+}
+
+func (d *Directory) ttl() time.Duration {
+	if d.TTL <= 0 {
+		return DefaultDirectoryTTL
+	}
+	return d.TTL
+}
+
+func (d *Directory) get(key string) (any, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.cache[key]
+	if !ok || time.Now().After(e.expire) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (d *Directory) set(key string, v any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cache[key] = dirEntry{value: v, expire: time.Now().Add(d.ttl())}
+}
+
+// Reset 清空缓存
+func (d *Directory) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cache = map[string]dirEntry{}
+	d.emailIndex = nil
+}
+
+// notFound 钉钉用户不存在的错误码转换成 ErrUserNotFound
+func notFound(err error, id string) error {
+	var de *Error
+	if errors.As(err, &de) && (de.ErrCode == 60121 || de.ErrCode == 33012) {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, id)
+	}
+	return err
+}
+
+// UserIdByMobile 根据手机号查找userId
+func (d *Directory) UserIdByMobile(mobile string) (string, error) {
+	if m := mobileRe.FindStringSubmatch(normalizeMobile(mobile)); m != nil {
+		mobile = m[2]
+	}
+	key := "mobile:" + mobile
+	if v, ok := d.get(key); ok {
+		return v.(string), nil
+	}
+	var result struct {
+		UserId string `json:"userid"`
+	}
+	err := d.client.callOapi(d.client.oapiUrl(UserGetByMobilePath), map[string]string{"mobile": mobile}, &result)
+	if err != nil {
+		return "", notFound(err, mobile)
+	}
+	if result.UserId == "" {
+		return "", fmt.Errorf("%w: %s", ErrUserNotFound, mobile)
+	}
+	d.set(key, result.UserId)
+	return result.UserId, nil
+}
+
+// User 查询用户详情
+func (d *Directory) User(userId string) (*User, error) {
+	key := "user:" + userId
+	if v, ok := d.get(key); ok {
+		return v.(*User), nil
+	}
+	var u User
+	if err := d.client.callOapi(d.client.oapiUrl(UserGetPath), map[string]string{"userid": userId}, &u); err != nil {
+		return nil, notFound(err, userId)
+	}
+	d.set(key, &u)
+	return &u, nil
+}
+
+// DepartmentUsers 获取部门的直属用户，不包括子部门
+func (d *Directory) DepartmentUsers(deptId int64) ([]*User, error) {
+	key := fmt.Sprintf("deptUsers:%d", deptId)
+	if v, ok := d.get(key); ok {
+		return v.([]*User), nil
+	}
+	var users []*User
+	cursor := int64(0)
+	for {
+		var result struct {
+			HasMore    bool    `json:"has_more"`
+			NextCursor int64   `json:"next_cursor"`
+			List       []*User `json:"list"`
+		}
+		req := map[string]int64{"dept_id": deptId, "cursor": cursor, "size": 100}
+		if err := d.client.callOapi(d.client.oapiUrl(UserListPath), req, &result); err != nil {
+			return nil, err
+		}
+		users = append(users, result.List...)
+		if !result.HasMore {
+			break
+		}
+		cursor = result.NextCursor
+	}
+	d.set(key, users)
+	for _, u := range users {
+		d.set("user:"+u.UserId, u)
+	}
+	return users, nil
+}
+
+// SubDepartments 获取直属子部门
+func (d *Directory) SubDepartments(deptId int64) ([]*Department, error) {
+	key := fmt.Sprintf("subDepts:%d", deptId)
+	if v, ok := d.get(key); ok {
+		return v.([]*Department), nil
+	}
+	var depts []*Department
+	if err := d.client.callOapi(d.client.oapiUrl(DepartmentListSubPath), map[string]int64{"dept_id": deptId}, &depts); err != nil {
+		return nil, err
+	}
+	d.set(key, depts)
+	return depts, nil
+}
+
+// UserIdByEmail 根据邮箱或企业邮箱查找userId，第一次查找时遍历 EmailDeptIds 部门的用户建立索引，索引过期后重建
+func (d *Directory) UserIdByEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	d.mu.Lock()
+	index, fresh := d.emailIndex, time.Now().Before(d.emailExpire)
+	d.mu.Unlock()
+	if index == nil || !fresh {
+		var err error
+		if index, err = d.buildEmailIndex(); err != nil {
+			return "", err
+		}
+	}
+	if id, ok := index[email]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUserNotFound, email)
+}
+
+// buildEmailIndex 遍历部门及其子部门的用户，建立邮箱到userId的索引
+func (d *Directory) buildEmailIndex() (map[string]string, error) {
+	roots := d.EmailDeptIds
+	if len(roots) == 0 {
+		roots = []int64{RootDeptId}
+	}
+	index := map[string]string{}
+	seen := map[int64]bool{}
+	queue := append([]int64{}, roots...)
+	for len(queue) > 0 {
+		deptId := queue[0]
+		queue = queue[1:]
+		if seen[deptId] {
+			continue
+		}
+		seen[deptId] = true
+		users, err := d.DepartmentUsers(deptId)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			for _, e := range []string{u.Email, u.OrgEmail} {
+				if e != "" {
+					index[strings.ToLower(e)] = u.UserId
+				}
+			}
+		}
+		subs, err := d.SubDepartments(deptId)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range subs {
+			queue = append(queue, s.DeptId)
+		}
+	}
+	d.mu.Lock()
+	d.emailIndex = index
+	d.emailExpire = time.Now().Add(d.ttl())
+	d.mu.Unlock()
+	return index, nil
+}
+
+// normalizeMobile 去掉手机号中的空格和-
+func normalizeMobile(s string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s))
+}
+
+// UserIdKind 用户标识的类型
+type UserIdKind string
+
+const (
+	// UserIdKindUserId 钉钉的userId
+	UserIdKindUserId UserIdKind = "userId"
+	// UserIdKindMobile 手机号，可以带+86、空格和-
+	UserIdKindMobile UserIdKind = "mobile"
+	// UserIdKindEmail 邮箱或企业邮箱
+	UserIdKindEmail UserIdKind = "email"
+)
+
+// UserRef 一个用户的标识，由调用者说明是userId、手机号还是邮箱，用 ByUserId、ByMobile、ByEmail 创建
+type UserRef struct {
+	Kind  UserIdKind
+	Value string
+}
+
+// ByUserId 用userId标识用户
+func ByUserId(userId string) UserRef {
+	return UserRef{Kind: UserIdKindUserId, Value: userId}
+}
+
+// ByMobile 用手机号标识用户
+func ByMobile(mobile string) UserRef {
+	return UserRef{Kind: UserIdKindMobile, Value: mobile}
+}
+
+// ByEmail 用邮箱标识用户
+func ByEmail(email string) UserRef {
+	return UserRef{Kind: UserIdKindEmail, Value: email}
+}
+
+func (r UserRef) String() string {
+	return string(r.Kind) + ":" + r.Value
+}
+
+// ResolveUserId 把用户标识转换成userId，按ref.Kind查找，不猜测标识的类型
+func (d *Directory) ResolveUserId(ref UserRef) (string, error) {
+	v := strings.TrimSpace(ref.Value)
+	switch ref.Kind {
+	case UserIdKindUserId:
+		return v, nil
+	case UserIdKindMobile:
+		return d.UserIdByMobile(v)
+	case UserIdKindEmail:
+		return d.UserIdByEmail(v)
+	default:
+		return "", fmt.Errorf("%w: unknown user id kind %q", ErrInvalidParam, ref.Kind)
+	}
+}
+
+// ResolveUserIds 把多个用户标识转换成userId并去重，找不到的用户返回合并后的错误，找到的仍然返回
+func (d *Directory) ResolveUserIds(refs ...UserRef) ([]string, error) {
+	var res []string
+	var errs []error
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		userId, err := d.ResolveUserId(ref)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !seen[userId] {
+			seen[userId] = true
+			res = append(res, userId)
+		}
+	}
+	return res, errors.Join(errs...)
+}
+
+// AtUsers 把用户标识转换成userId后@到msg，只有webhook方式支持@
+func (d *Directory) AtUsers(msg *Message, refs ...UserRef) (*Message, error) {
+	userIds, err := d.ResolveUserIds(refs...)
+	return msg.WithAtUserIds(userIds...), err
+}
+
+// OtOSender 把用户标识转换成userId后，返回发送给这些用户的单聊Sender。部分用户找不到时同时返回Sender和错误
+func (d *Directory) OtOSender(o *OtOClient, refs ...UserRef) (*OtOSender, error) {
+	userIds, err := d.ResolveUserIds(refs...)
+	if len(userIds) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%w: no recipients", ErrUserNotFound)
+		}
+		return nil, err
+	}
+	return o.Sender(userIds...), err
+}
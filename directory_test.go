@@ -0,0 +1,227 @@
+package ding_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// fakeDirectory 在假服务上实现通讯录接口：部门1有u1，子部门2有u2
+type fakeDirectory struct {
+	calls atomic.Int64
+}
+
+func (f *fakeDirectory) install(srv *dingtest.Server) {
+	users := map[int64][]*ding.User{
+		1: {{UserId: "u1", Name: "张三", Mobile: "13800000001", Email: "zhangsan@example.com"}},
+		2: {{UserId: "u2", Name: "李四", Mobile: "13800000002", OrgEmail: "LiSi@corp.example.com"}},
+	}
+	subs := map[int64][]*ding.Department{1: {{DeptId: 2, Name: "研发", ParentId: 1}}}
+
+	handle := func(path string, fn func(req map[string]any) (any, *ding.Error)) {
+		srv.Handle(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f.calls.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			if _, ok := srv.AppKey(r); !ok {
+				_ = json.NewEncoder(w).Encode(&ding.Error{ErrCode: 40014, ErrMsg: "invalid access_token"})
+				return
+			}
+			var req map[string]any
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			result, derr := fn(req)
+			if derr != nil {
+				_ = json.NewEncoder(w).Encode(derr)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"errcode": 0, "errmsg": "ok", "result": result})
+		}))
+	}
+	handle(ding.UserGetByMobilePath, func(req map[string]any) (any, *ding.Error) {
+		for _, list := range users {
+			for _, u := range list {
+				if u.Mobile == req["mobile"] {
+					return map[string]string{"userid": u.UserId}, nil
+				}
+			}
+		}
+		return nil, &ding.Error{ErrCode: 60121, ErrMsg: "找不到该用户"}
+	})
+	handle(ding.UserListPath, func(req map[string]any) (any, *ding.Error) {
+		deptId, _ := req["dept_id"].(float64)
+		return map[string]any{"has_more": false, "list": users[int64(deptId)]}, nil
+	})
+	handle(ding.DepartmentListSubPath, func(req map[string]any) (any, *ding.Error) {
+		deptId, _ := req["dept_id"].(float64)
+		return subs[int64(deptId)], nil
+	})
+}
+
+// newTestDirectory 创建使用假服务的通讯录和单聊客户端
+func newTestDirectory(t *testing.T) (*ding.Directory, *ding.OtOClient, *fakeDirectory, *dingtest.Server) {
+	t.Helper()
+	srv := dingtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddApp("app-key", "app-secret")
+	f := &fakeDirectory{}
+	f.install(srv)
+	o, err := srv.NewOtOClient("robot", "app-key", "app-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ding.NewDirectory(o.IClient), o, f, srv
+}
+
+func TestDirectoryResolveUserId(t *testing.T) {
+	d, _, _, _ := newTestDirectory(t)
+
+	tests := []struct {
+		name    string
+		ref     ding.UserRef
+		want    string
+		wantErr error
+	}{
+		{name: "userId", ref: ding.ByUserId(" u9 "), want: "u9"},
+		{name: "mobile", ref: ding.ByMobile("13800000001"), want: "u1"},
+		{name: "mobile with +86 and dashes", ref: ding.ByMobile("+86 138-0000-0002"), want: "u2"},
+		{name: "unknown mobile", ref: ding.ByMobile("13900000000"), wantErr: ding.ErrUserNotFound},
+		{name: "email", ref: ding.ByEmail("ZhangSan@example.com"), want: "u1"},
+		{name: "org email in sub department", ref: ding.ByEmail("lisi@corp.example.com"), want: "u2"},
+		{name: "unknown email", ref: ding.ByEmail("nobody@example.com"), wantErr: ding.ErrUserNotFound},
+		{name: "unknown kind", ref: ding.UserRef{Kind: "name", Value: "张三"}, wantErr: ding.ErrInvalidParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.ResolveUserId(tt.ref)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveUserId(%s) err = %v, want %v", tt.ref, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveUserId(%s) = %q, want %q", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectoryCache(t *testing.T) {
+	d, _, f, _ := newTestDirectory(t)
+
+	for _, m := range []string{"13800000001", "+8613800000001", "138 0000 0001"} {
+		if id, err := d.UserIdByMobile(m); err != nil || id != "u1" {
+			t.Fatalf("UserIdByMobile(%q) = %q, %v", m, id, err)
+		}
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Errorf("mobile lookups called the api %d times, want 1", n)
+	}
+
+	// 邮箱索引：部门1的用户、子部门、部门2的用户、部门2的子部门，共4次
+	f.calls.Store(0)
+	for i := 0; i < 3; i++ {
+		if id, err := d.UserIdByEmail("zhangsan@example.com"); err != nil || id != "u1" {
+			t.Fatalf("UserIdByEmail = %q, %v", id, err)
+		}
+	}
+	if n := f.calls.Load(); n != 4 {
+		t.Errorf("building the email index called the api %d times, want 4", n)
+	}
+	// 部门用户已经缓存，查询用户详情不再请求
+	f.calls.Store(0)
+	if u, err := d.User("u2"); err != nil || u.Name != "李四" {
+		t.Fatalf("User(u2) = %+v, %v", u, err)
+	}
+	if n := f.calls.Load(); n != 0 {
+		t.Errorf("cached User called the api %d times, want 0", n)
+	}
+
+	// 找不到的用户不缓存
+	for i := 0; i < 2; i++ {
+		if _, err := d.UserIdByMobile("13900000000"); !errors.Is(err, ding.ErrUserNotFound) {
+			t.Fatalf("unknown mobile err = %v", err)
+		}
+	}
+	if n := f.calls.Load(); n != 2 {
+		t.Errorf("unknown mobile called the api %d times, want 2", n)
+	}
+
+	f.calls.Store(0)
+	d.Reset()
+	if _, err := d.UserIdByMobile("13800000001"); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Errorf("lookup after Reset called the api %d times, want 1", n)
+	}
+}
+
+func TestDirectoryTTL(t *testing.T) {
+	d, _, f, _ := newTestDirectory(t)
+	d.TTL = 50 * time.Millisecond
+
+	for _, wait := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		time.Sleep(wait)
+		if _, err := d.UserIdByMobile("13800000001"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.calls.Load(); n != 2 {
+		t.Errorf("called the api %d times, want 2 after the cache expired", n)
+	}
+}
+
+func TestDirectoryResolveUserIds(t *testing.T) {
+	d, o, _, srv := newTestDirectory(t)
+
+	ids, err := d.ResolveUserIds(ding.ByUserId("u1"), ding.ByMobile("13800000001"), ding.ByEmail("lisi@corp.example.com"), ding.ByMobile("13900000000"))
+	if !errors.Is(err, ding.ErrUserNotFound) {
+		t.Errorf("err = %v, want ErrUserNotFound for the unknown mobile", err)
+	}
+	if len(ids) != 2 || ids[0] != "u1" || ids[1] != "u2" {
+		t.Errorf("ids = %v, want [u1 u2]", ids)
+	}
+
+	msg, err := d.AtUsers(ding.NewTextMessage("deploy"), ding.ByMobile("13800000002"))
+	if err != nil || len(msg.At.AtUserIds) != 1 || msg.At.AtUserIds[0] != "u2" {
+		t.Errorf("AtUsers = %+v, %v", msg.At, err)
+	}
+
+	if _, err = d.OtOSender(o, ding.ByMobile("13900000000")); !errors.Is(err, ding.ErrUserNotFound) {
+		t.Errorf("OtOSender without recipients err = %v", err)
+	}
+	s, err := d.OtOSender(o, ding.ByMobile("13800000001"), ding.ByEmail("lisi@corp.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Send(ding.NewTextMessage("hello")); err != nil {
+		t.Fatal(err)
+	}
+	msgs := srv.Messages(dingtest.EndpointOtO)
+	if len(msgs) != 1 || len(msgs[0].UserIds) != 2 {
+		t.Fatalf("received %+v, want one message to u1 and u2", msgs)
+	}
+}
+
+func TestDirectoryConcurrent(t *testing.T) {
+	d, _, _, _ := newTestDirectory(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				d.Reset()
+			}
+			ids, err := d.ResolveUserIds(ding.ByMobile("13800000001"), ding.ByEmail("lisi@corp.example.com"))
+			if err != nil || len(ids) != 2 {
+				t.Errorf("ResolveUserIds = %v, %v", ids, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,84 @@
+package ding
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrCodeSendTooFast webhook 发送太快被限流，每个机器人每分钟最多发送20条
+	ErrCodeSendTooFast = 130101
+	// ErrCodeFlowControl 发送太快被限流
+	ErrCodeFlowControl = 410100
+	// ErrCodeSecurityCheck 安全设置校验失败：关键字、加签或IP地址不匹配
+	ErrCodeSecurityCheck = 310000
+	// ErrCodeTokenNotExist access_token不存在
+	ErrCodeTokenNotExist = 300001
+	// ErrCodeInvalidToken 不合法的access_token
+	ErrCodeInvalidToken = 40014
+)
+
+// Error 钉钉返回的错误
+// webhook 和旧版(oapi)接口通过 errcode/errmsg 返回错误，新版(api)接口通过非2xx状态码和 code/message 返回错误
+type Error struct {
+	// HTTP状态码
+	StatusCode int `json:"-"`
+	// webhook 和旧版接口返回的错误码，0表示成功
+	ErrCode int `json:"errcode"`
+	// webhook 和旧版接口返回的错误信息
+	ErrMsg string `json:"errmsg"`
+	// 新版接口返回的错误码
+	Code string `json:"code"`
+	// 新版接口返回的错误信息
+	Message string `json:"message"`
+	// 新版接口返回的请求id，排查问题时提供给钉钉
+	RequestId string `json:"requestid"`
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("ding: status %d, code %s: %s (requestid: %s)", e.StatusCode, e.Code, e.Message, e.RequestId)
+	}
+	if e.ErrCode != 0 {
+		return fmt.Sprintf("ding: errcode %d: %s", e.ErrCode, e.ErrMsg)
+	}
+	return fmt.Sprintf("ding: status %d: %s", e.StatusCode, e.Message)
+}
+
+// IsRateLimited 是否被钉钉限流
+func (e *Error) IsRateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.ErrCode == ErrCodeSendTooFast ||
+		e.ErrCode == ErrCodeFlowControl ||
+		strings.Contains(e.Code, "QpsLimit") ||
+		strings.Contains(e.Code, "Throttling")
+}
+
+// IsAuthFailed 是否是access_token、加签、关键字等鉴权失败
+func (e *Error) IsAuthFailed() bool {
+	return e.StatusCode == http.StatusUnauthorized ||
+		e.StatusCode == http.StatusForbidden && !e.IsRateLimited() ||
+		e.ErrCode == ErrCodeSecurityCheck ||
+		e.ErrCode == ErrCodeTokenNotExist ||
+		e.ErrCode == ErrCodeInvalidToken ||
+		e.Code == "InvalidAuthentication"
+}
+
+// parseDingResp 解析钉钉的回复，有错误时返回 *Error
+func parseDingResp(statusCode int, body []byte) error {
+	e := &Error{StatusCode: statusCode}
+	// 有些接口成功时回复为空，或者不是json
+	_ = json.Unmarshal(body, e)
+	if statusCode/100 != 2 {
+		if e.Code == "" && e.ErrCode == 0 {
+			e.Message = strings.TrimSpace(string(body))
+		}
+		return e
+	}
+	if e.ErrCode != 0 {
+		return e
+	}
+	return nil
+}
@@ -1,5 +1,3 @@
 module github.com/wanghkkk/ding
 
-go 1.19
-
-require github.com/allegro/bigcache/v3 v3.0.2
+go 1.21
@@ -0,0 +1,204 @@
+// 互动卡片，机器人发送卡片到群或单聊后，可以通过outTrackId更新卡片的数据，用于部署进度、事件状态等需要原地更新的消息
+// 参考： https://open.dingtalk.com/document/orgapp/send-interactive-dynamic-cards-1
+// https://open.dingtalk.com/document/orgapp/update-dingtalk-interactive-cards-1
+
+package ding
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// 互动卡片接口的路径，地址为客户端的新版接口地址加上路径，见 WithBaseUrl
+const (
+	// InteractiveCardSendPath 发送互动卡片
+	InteractiveCardSendPath = "/v1.0/im/interactiveCards/send"
+	// InteractiveCardUpdatePath 更新互动卡片
+	InteractiveCardUpdatePath = "/v1.0/im/interactiveCards"
+)
+
+// 卡片模板中常用的变量名，CardData 的同名方法设置这些变量，模板中的变量名需要一致
+var (
+	CardKeyTitle     = "title"
+	CardKeyMarkdown  = "markdown"
+	CardKeyStatus    = "status"
+	CardKeyProgress  = "progress"
+	CardKeyUpdatedAt = "updatedAt"
+	CardKeyButtons   = "buttons"
+)
+
+// CardData 卡片模板变量的值，钉钉要求都是字符串，复杂的值用 SetJSON 设置
+type CardData map[string]string
+
+// NewCardData 创建卡片数据
+func NewCardData() CardData {
+	return CardData{}
+}
+
+// Set 设置字符串变量
+func (d CardData) Set(key, value string) CardData {
+	d[key] = value
+	return d
+}
+
+// SetInt 设置数字变量
+func (d CardData) SetInt(key string, value int) CardData {
+	d[key] = strconv.Itoa(value)
+	return d
+}
+
+// SetBool 设置布尔变量
+func (d CardData) SetBool(key string, value bool) CardData {
+	d[key] = strconv.FormatBool(value)
+	return d
+}
+
+// SetJSON 设置数组、对象等复杂变量，序列化成json字符串，value不能序列化时返回错误，不设置这个变量
+func (d CardData) SetJSON(key string, value any) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w: card data %q is not json serializable: %s", ErrInvalidParam, key, err)
+	}
+	d[key] = string(b)
+	return nil
+}
+
+// Title 设置 CardKeyTitle
+func (d CardData) Title(title string) CardData {
+	return d.Set(CardKeyTitle, title)
+}
+
+// Markdown 设置 CardKeyMarkdown
+func (d CardData) Markdown(text string) CardData {
+	return d.Set(CardKeyMarkdown, text)
+}
+
+// Status 设置 CardKeyStatus，如 部署中、已完成
+func (d CardData) Status(status string) CardData {
+	return d.Set(CardKeyStatus, status)
+}
+
+// Progress 设置 CardKeyProgress，0~100
+func (d CardData) Progress(percent int) CardData {
+	return d.SetInt(CardKeyProgress, percent)
+}
+
+// UpdatedAt 设置 CardKeyUpdatedAt，格式为 2006-01-02 15:04:05
+func (d CardData) UpdatedAt(t time.Time) CardData {
+	return d.Set(CardKeyUpdatedAt, t.Format("2006-01-02 15:04:05"))
+}
+
+// Buttons 设置 CardKeyButtons，按钮为跳转链接
+func (d CardData) Buttons(btns ...*Btn) CardData {
+	// Btn只有字符串字段，序列化不会失败
+	b, _ := json.Marshal(btns)
+	return d.Set(CardKeyButtons, string(b))
+}
+
+// CardSendReq 发送互动卡片的参数，OpenConversationId 和 UserIds 二选一
+type CardSendReq struct {
+	// 卡片模板id，在卡片平台创建
+	TemplateId string
+	// 卡片的唯一id，用于更新卡片，为空时自动生成
+	OutTrackId string
+	// 发送到这个群
+	OpenConversationId string
+	// 单聊发送给这些用户
+	UserIds []string
+	// 卡片模板变量的值
+	Data CardData
+	// 卡片回调的路由key，有按钮等交互时需要，见卡片回调
+	CallbackRouteKey string
+}
+
+// Card 已经发送的互动卡片
+type Card struct {
+	// 卡片的唯一id
+	OutTrackId string
+	// 发送后钉钉返回的查询key
+	ProcessQueryKey string
+	client          *IClient
+}
+
+// Update 更新卡片的数据，只更新data中的变量
+func (c *Card) Update(data CardData) error {
+	return c.client.UpdateCard(c.OutTrackId, data)
+}
+
+// NewOutTrackId 生成卡片的唯一id
+func NewOutTrackId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SendCard 以机器人的身份发送互动卡片
+func (c *IClient) SendCard(req *CardSendReq) (*Card, error) {
+	if req.TemplateId == "" {
+		return nil, fmt.Errorf("%w: card templateId is required", ErrInvalidParam)
+	}
+	if (req.OpenConversationId == "") == (len(req.UserIds) == 0) {
+		return nil, fmt.Errorf("%w: exactly one of openConversationId and userIds is required", ErrInvalidRecipients)
+	}
+	outTrackId := req.OutTrackId
+	if outTrackId == "" {
+		outTrackId = NewOutTrackId()
+	}
+	data := req.Data
+	if data == nil {
+		data = CardData{}
+	}
+	body := map[string]any{
+		"cardTemplateId": req.TemplateId,
+		"outTrackId":     outTrackId,
+		"robotCode":      c.RobotCode,
+		"cardData":       map[string]any{"cardParamMap": data},
+	}
+	if req.OpenConversationId != "" {
+		body["conversationType"] = 1
+		body["openConversationId"] = req.OpenConversationId
+	} else {
+		body["conversationType"] = 0
+		body["receiverUserIdList"] = req.UserIds
+	}
+	if req.CallbackRouteKey != "" {
+		body["callbackRouteKey"] = req.CallbackRouteKey
+	}
+	var resp struct {
+		Result struct {
+			ProcessQueryKey string `json:"processQueryKey"`
+		} `json:"result"`
+	}
+	if err := c.callApi(http.MethodPost, c.apiUrl(InteractiveCardSendPath), body, &resp); err != nil {
+		return nil, err
+	}
+	return &Card{OutTrackId: outTrackId, ProcessQueryKey: resp.Result.ProcessQueryKey, client: c}, nil
+}
+
+// SendCardToGroup 发送互动卡片到openConversationId这个群，outTrackId为空时自动生成
+func (c *IClient) SendCardToGroup(templateId, openConversationId, outTrackId string, data CardData) (*Card, error) {
+	return c.SendCard(&CardSendReq{TemplateId: templateId, OpenConversationId: openConversationId, OutTrackId: outTrackId, Data: data})
+}
+
+// SendCardToUsers 单聊发送互动卡片给userIds这些用户，outTrackId为空时自动生成
+func (c *IClient) SendCardToUsers(templateId, outTrackId string, data CardData, userIds ...string) (*Card, error) {
+	return c.SendCard(&CardSendReq{TemplateId: templateId, UserIds: userIds, OutTrackId: outTrackId, Data: data})
+}
+
+// UpdateCard 更新outTrackId这张卡片的数据，只更新data中的变量，其他变量保持不变
+func (c *IClient) UpdateCard(outTrackId string, data CardData) error {
+	if outTrackId == "" {
+		return fmt.Errorf("%w: outTrackId is required", ErrInvalidParam)
+	}
+	body := map[string]any{
+		"outTrackId":  outTrackId,
+		"cardData":    map[string]any{"cardParamMap": data},
+		"cardOptions": map[string]bool{"updateCardDataByKey": true},
+	}
+	return c.callApi(http.MethodPut, c.apiUrl(InteractiveCardUpdatePath), body, nil)
+}
@@ -5,19 +5,16 @@
 package ding
 
 import (
-	"bytes"
 	"encoding/json"
-	"github.com/allegro/bigcache/v3"
-	"io"
-	"log"
+	"fmt"
 	"net/http"
-	"time"
 )
 
 var (
-	oToMessageBatchSendUrl = "https://api.dingtalk.com/v1.0/robot/oToMessages/batchSend"
-	groupMessageSendUrl    = "https://api.dingtalk.com/v1.0/robot/groupMessages/send"
-	cache                  *bigcache.BigCache
+	// OtOMessageBatchSendUrl 批量发送单聊消息的接口地址，创建客户端时读取，测试时可以替换
+	OtOMessageBatchSendUrl = "https://api.dingtalk.com/v1.0/robot/oToMessages/batchSend"
+	// GroupMessageSendUrl 发送群聊消息的接口地址，创建客户端时读取，测试时可以替换
+	GroupMessageSendUrl = "https://api.dingtalk.com/v1.0/robot/groupMessages/send"
 )
 
 // OtOClient 单聊客户端
@@ -32,9 +29,18 @@ type GroupClient struct {
 
 // IClient 接口方式发送消息，支持群聊和单聊
 type IClient struct {
-	url       string
-	RobotCode string `json:"robotCode"`
+	url string
+	// 新版和旧版接口的地址，为空时使用 DefaultApiBaseUrl、DefaultOapiBaseUrl，见 WithBaseUrl
+	apiBaseUrl  string
+	oapiBaseUrl string
+	RobotCode   string `json:"robotCode"`
 	AppKeySecret
+	// 获取accessToken，为nil时使用 DefaultTokenProvider
+	TokenProvider TokenProvider
+	// 发送中间件、钩子和重试
+	Pipeline
+
+	transport
 }
 
 // OtOMessageBody 发送单聊post body
@@ -71,79 +77,76 @@ type RobotCodeMsgKeyParam struct {
 	RobotCode string `json:"robotCode"`
 }
 
-func initCache() {
-	// 钉钉默认的accessToken 有效期为7200秒（2小时）
-	var err error
-	cache, err = bigcache.NewBigCache(bigcache.DefaultConfig(7000 * time.Second))
-	if err != nil {
-		log.Fatalf("init BigCache failed: %s\n", err)
-	}
-}
-
 // NewOtOClient 创建单聊客户端
 func NewOtOClient(robotCode string, appKey, appSecret string) *OtOClient {
-	initCache()
-	return &OtOClient{
-		IClient: &IClient{
-			url:       oToMessageBatchSendUrl,
-			RobotCode: robotCode,
-			AppKeySecret: AppKeySecret{
-				AppKey:    appKey,
-				AppSecret: appSecret,
-			},
-		},
-	}
+	return &OtOClient{IClient: newIClient(OtOMessageBatchSendUrl, robotCode, appKey, appSecret)}
 }
 
 // NewGroupClient 创建群聊客户端
 func NewGroupClient(robotCode string, appKey, appSecret string) *GroupClient {
-	initCache()
-	return &GroupClient{
-		IClient: &IClient{
-			url:       groupMessageSendUrl,
-			RobotCode: robotCode,
-			AppKeySecret: AppKeySecret{
-				AppKey:    appKey,
-				AppSecret: appSecret,
-			},
-		},
-	}
+	return &GroupClient{IClient: newIClient(GroupMessageSendUrl, robotCode, appKey, appSecret)}
 }
 
-// 通过接口的方式发送钉钉消息
-func (c *IClient) sendDingInterfaceMsg(url string, msg any) error {
-	msgByte, err := json.Marshal(msg)
+// NewOtOClientWithOptions 创建单聊客户端，参数不合法时返回错误
+func NewOtOClientWithOptions(robotCode, appKey, appSecret string, opts ...Option) (*OtOClient, error) {
+	if robotCode == "" {
+		return nil, fmt.Errorf("%w: robotCode is required", ErrInvalidClientConfig)
+	}
+	c, err := newIClientWithOptions(OtOMessageBatchSendUrl, robotCode, appKey, appSecret, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &OtOClient{IClient: c}, nil
+}
 
-	request, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(msgByte))
-	if err != nil {
-		return err
+// NewGroupClientWithOptions 创建群聊客户端，参数不合法时返回错误
+func NewGroupClientWithOptions(robotCode, appKey, appSecret string, opts ...Option) (*GroupClient, error) {
+	if robotCode == "" {
+		return nil, fmt.Errorf("%w: robotCode is required", ErrInvalidClientConfig)
 	}
-	accessToken, err := GetAccessToken(AppKeySecret{AppKey: c.AppKey, AppSecret: c.AppSecret})
+	c, err := newIClientWithOptions(GroupMessageSendUrl, robotCode, appKey, appSecret, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	client := http.Client{Timeout: 2 * time.Second}
-	request.Header.Set("x-acs-dingtalk-access-token", accessToken)
-	request.Header.Set("Content-Type", ContentTypeJson)
+	return &GroupClient{IClient: c}, nil
+}
 
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
+func newIClient(url, robotCode, appKey, appSecret string) *IClient {
+	return &IClient{
+		url:       url,
+		RobotCode: robotCode,
+		AppKeySecret: AppKeySecret{
+			AppKey:    appKey,
+			AppSecret: appSecret,
+		},
 	}
+}
 
-	defer resp.Body.Close()
-
-	respByte, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+func newIClientWithOptions(url, robotCode, appKey, appSecret string, opts []Option) (*IClient, error) {
+	if appKey == "" || appSecret == "" {
+		return nil, fmt.Errorf("%w: appKey and appSecret are required", ErrInvalidClientConfig)
+	}
+	o := newClientOptions(opts)
+	c := newIClient(url, robotCode, appKey, appSecret)
+	c.setOptions(o)
+	o.installPipeline(&c.Pipeline)
+	if o.endpoint != "" {
+		c.url = o.endpoint
 	}
-	if Debug {
-		log.Printf("发送钉钉接口消息后，收到钉钉的回复: %v\n", string(respByte))
+	c.apiBaseUrl, c.oapiBaseUrl = o.apiBaseUrl, o.oapiBaseUrl
+	switch {
+	case o.tokenProvider != nil:
+		c.TokenProvider = o.tokenProvider
+	case o.accessTokenUrl != "" || o.httpClient != nil:
+		// 地址或http.Client不同，不能和其他客户端共用cache
+		c.TokenProvider = &CacheTokenProvider{Url: o.accessTokenUrl, HTTPClient: o.httpClient}
 	}
-	return nil
+	return c, nil
+}
+
+// 通过接口的方式发送钉钉消息
+func (c *IClient) sendDingInterfaceMsg(url string, msg any) error {
+	return c.callApi(http.MethodPost, url, msg, nil)
 }
 
 func (c *IClient) createRobotCodeMessageKeyParam(msgKey, msgParam string) *RobotCodeMsgKeyParam {
@@ -172,56 +175,47 @@ func (c *IClient) createGroupMessageBody(openConversationId, msgKey, msgParam st
 
 // SendTextMsgWithUserIds 发送单聊文本消息给userIds这些用户，可以从postReq.senderStaffId 获取
 func (o *OtOClient) SendTextMsgWithUserIds(content string, userIds []string) error {
-	msg := o.createOtOMessageBody(IMsgKeyText, Text{Content: content}.String(), userIds)
-	return o.sendDingInterfaceMsg(o.url, msg)
+	return o.Sender(userIds...).Send(NewTextMessage(content))
 }
 
 // SendMarkdownMsgWithUserIds 发送单聊markdown消息给userIds这些用户，可以从postReq.senderStaffId 获取
 func (o *OtOClient) SendMarkdownMsgWithUserIds(title, text string, userIds []string) error {
-	msg := o.createOtOMessageBody(IMsgKeyMarkdown, (&Markdown{Title: title, Text: text}).String(), userIds)
-	return o.sendDingInterfaceMsg(o.url, msg)
+	return o.Sender(userIds...).Send(NewMarkdownMessage(title, text))
 }
 
 // SendImageMsg 发送单聊图片消息给userIds这些用户，可以从postReq.senderStaffId 获取
 func (o *OtOClient) SendImageMsg(photoURL string, userIds []string) error {
-	msg := o.createOtOMessageBody(IMsgKeyImage, (&Image{PhotoURL: photoURL}).String(), userIds)
-	return o.sendDingInterfaceMsg(o.url, msg)
+	return o.Sender(userIds...).Send(NewImageMessage(photoURL))
 }
 
 // SendLinkMsg 发送单聊Link链接消息给userIds这些用户，可以从postReq.senderStaffId 获取
 func (o *OtOClient) SendLinkMsg(title, text, picUrl, messageUrl string, userIds []string) error {
-	msg := o.createOtOMessageBody(IMsgKeyLink, NewLink(title, text, picUrl, messageUrl).String(), userIds)
-	return o.sendDingInterfaceMsg(o.url, msg)
+	return o.Sender(userIds...).Send(NewLinkMessage(title, text, picUrl, messageUrl))
 }
 
 func (o *OtOClient) SendActionCardMsg(title, text, singleTitle, singleURL string, userIds []string) error {
-	msg := o.createOtOMessageBody(IMsgKeyActionCard, NewEntiretyActionCard(title, text, singleTitle, singleURL).String(), userIds)
-	return o.sendDingInterfaceMsg(o.url, msg)
+	return o.Sender(userIds...).Send(NewActionCardMessage(title, text, singleTitle, singleURL))
 }
 
 // SendTextMsg 发送群聊文本消息给conversationId这个群，可以从postReq.conversationId 获取
 func (g *GroupClient) SendTextMsg(content, conversationId string) error {
-	msg := g.createGroupMessageBody(conversationId, IMsgKeyText, (&Text{Content: content}).String())
-	return g.sendDingInterfaceMsg(g.url, msg)
+	return g.Sender(conversationId).Send(NewTextMessage(content))
 }
 
 // SendMarkdownMsg 发送群聊markdown消息给conversationId这个群，可以从postReq.conversationId 获取
 func (g *GroupClient) SendMarkdownMsg(title, text, conversationId string) error {
-	return g.sendDingInterfaceMsg(g.url, g.createGroupMessageBody(conversationId, IMsgKeyMarkdown, (&Markdown{Title: title, Text: text}).String()))
+	return g.Sender(conversationId).Send(NewMarkdownMessage(title, text))
 }
 
 // SendImageMsg 发送群聊图片消息给conversationId群，可以从postReq.conversationId 获取
 func (g *GroupClient) SendImageMsg(photoURL, conversationId string) error {
-	msg := g.createGroupMessageBody(conversationId, IMsgKeyImage, (&Image{PhotoURL: photoURL}).String())
-	return g.sendDingInterfaceMsg(g.url, msg)
+	return g.Sender(conversationId).Send(NewImageMessage(photoURL))
 }
 
 // SendLinkMsg 发送群聊Link链接消息给conversationId群，可以从postReq.conversationId 获取
 func (g *GroupClient) SendLinkMsg(title, text, picUrl, messageUrl, conversationId string) error {
-	msg := g.createGroupMessageBody(conversationId, IMsgKeyLink, NewLink(title, text, picUrl, messageUrl).String())
-	return g.sendDingInterfaceMsg(g.url, msg)
+	return g.Sender(conversationId).Send(NewLinkMessage(title, text, picUrl, messageUrl))
 }
 func (g *GroupClient) SendActionCardMsg(title, text, singleTitle, singleURL, conversationId string) error {
-	msg := g.createGroupMessageBody(conversationId, IMsgKeyActionCard, NewEntiretyActionCard(title, text, singleTitle, singleURL).String())
-	return g.sendDingInterfaceMsg(g.url, msg)
+	return g.Sender(conversationId).Send(NewActionCardMessage(title, text, singleTitle, singleURL))
 }
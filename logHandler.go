@@ -0,0 +1,240 @@
+package ding
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultLogTitle 日志消息默认的标题前缀
+	DefaultLogTitle = "应用日志"
+	// DefaultLogRateLimit 日志消息默认每分钟最多发送的条数，钉钉机器人每分钟最多发送20条
+	DefaultLogRateLimit = 20
+)
+
+// LogOptions 日志发送到钉钉的配置
+type LogOptions struct {
+	// 最低的日志级别，为nil时为 slog.LevelError，只对 SlogHandler 有效
+	Level slog.Leveler
+	// 消息标题前缀，为空时使用 DefaultLogTitle
+	Title string
+	// 每个 RateInterval 最多发送的条数，超过的直接丢弃，<=0时使用 DefaultLogRateLimit
+	RateLimit int
+	// 限流的时间窗口，<=0时为一分钟
+	RateInterval time.Duration
+	// 异步发送队列的大小，队列满了丢弃新日志，<=0时使用 DefaultQueueSize
+	QueueSize int
+	// 是否在消息中带上源代码位置，只对 SlogHandler 有效
+	AddSource bool
+}
+
+// rateLimiter 固定窗口限流
+type rateLimiter struct {
+	limit    int
+	interval time.Duration
+
+	mu      sync.Mutex
+	start   time.Time
+	count   int
+	dropped int
+}
+
+func newRateLimiter(limit int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, interval: interval}
+}
+
+// allow 是否允许发送，返回上一个窗口被丢弃的数量
+func (r *rateLimiter) allow() (ok bool, dropped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.start) >= r.interval {
+		dropped = r.dropped
+		r.start = now
+		r.count = 0
+		r.dropped = 0
+	}
+	if r.count >= r.limit {
+		r.dropped++
+		return false, dropped
+	}
+	r.count++
+	return true, dropped
+}
+
+// logSender SlogHandler 和 LogWriter 共用的异步发送和限流
+type logSender struct {
+	title   string
+	queue   *Queue
+	limiter *rateLimiter
+}
+
+func newLogSender(sender Sender, opts *LogOptions) *logSender {
+	o := LogOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Title == "" {
+		o.Title = DefaultLogTitle
+	}
+	if o.RateLimit <= 0 {
+		o.RateLimit = DefaultLogRateLimit
+	}
+	if o.RateInterval <= 0 {
+		o.RateInterval = time.Minute
+	}
+	// 发送日志失败时不能再写日志，否则可能循环
+	q := NewQueue(sender, o.QueueSize, 1, OverflowDropNewest, WithQueueResult(func(*Message, error) {}))
+	return &logSender{
+		title:   o.Title,
+		queue:   q,
+		limiter: newRateLimiter(o.RateLimit, o.RateInterval),
+	}
+}
+
+func (s *logSender) send(title, text string) {
+	ok, dropped := s.limiter.allow()
+	if !ok {
+		return
+	}
+	if dropped > 0 {
+		text = fmt.Sprintf("%s\n\n> 上一个时间窗口内因限流丢弃了%d条日志", text, dropped)
+	}
+	_ = s.queue.Send(NewMarkdownMessage(title, text))
+}
+
+// SlogHandler 把 log/slog 的日志发送到钉钉的 slog.Handler，日志格式化为markdown，属性每行一个。
+// 异步发送，不阻塞写日志；超过限流的日志直接丢弃
+type SlogHandler struct {
+	s         *logSender
+	level     slog.Leveler
+	addSource bool
+	attrs     []slog.Attr
+	groups    []string
+}
+
+// NewSlogHandler 创建发送到sender的 slog.Handler，opts可以为nil
+func NewSlogHandler(sender Sender, opts *LogOptions) *SlogHandler {
+	h := &SlogHandler{s: newLogSender(sender, opts), level: slog.LevelError}
+	if opts != nil {
+		if opts.Level != nil {
+			h.level = opts.Level
+		}
+		h.addSource = opts.AddSource
+	}
+	return h
+}
+
+// Enabled 实现 slog.Handler
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle 实现 slog.Handler
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	title := fmt.Sprintf("[%s] %s", r.Level, h.s.title)
+	var b strings.Builder
+	fmt.Fprintf(&b, "### [%s] %s\n\n", r.Level, r.Message)
+	if !r.Time.IsZero() {
+		fmt.Fprintf(&b, "- **time**: %s\n", r.Time.Format("2006-01-02 15:04:05.000"))
+	}
+	if h.addSource && r.PC != 0 {
+		fs := runtime.CallersFrames([]uintptr{r.PC})
+		f, _ := fs.Next()
+		fmt.Fprintf(&b, "- **source**: %s:%d\n", f.File, f.Line)
+	}
+	for _, a := range h.attrs {
+		writeAttr(&b, "", a)
+	}
+	prefix := ""
+	if len(h.groups) > 0 {
+		prefix = strings.Join(h.groups, ".") + "."
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(&b, prefix, a)
+		return true
+	})
+	h.s.send(title, b.String())
+	return nil
+}
+
+// writeAttr 属性写成一行 - **key**: value，group展开为 group.key
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, prefix, ga)
+		}
+		return
+	}
+	fmt.Fprintf(b, "- **%s%s**: %s\n", prefix, a.Key, a.Value)
+}
+
+// WithAttrs 实现 slog.Handler
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	prefix := ""
+	if len(h.groups) > 0 {
+		prefix = strings.Join(h.groups, ".") + "."
+	}
+	h2.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		if prefix != "" {
+			a.Key = prefix + a.Key
+		}
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+// WithGroup 实现 slog.Handler
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(append([]string{}, h.groups...), name)
+	return &h2
+}
+
+// Shutdown 发送完队列中的日志，程序退出前调用
+func (h *SlogHandler) Shutdown(ctx context.Context) error {
+	return h.s.queue.Shutdown(ctx)
+}
+
+// LogWriter 把标准库 log 包的输出发送到钉钉的 io.Writer，每次Write为一条消息。
+// 异步发送，不阻塞写日志；超过限流的日志直接丢弃
+type LogWriter struct {
+	s *logSender
+}
+
+// NewLogWriter 创建发送到sender的 io.Writer，可以用于 log.New 或 log.SetOutput，opts可以为nil
+func NewLogWriter(sender Sender, opts *LogOptions) *LogWriter {
+	return &LogWriter{s: newLogSender(sender, opts)}
+}
+
+// Write 实现 io.Writer，总是返回len(p)
+func (w *LogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	if line == "" {
+		return len(p), nil
+	}
+	w.s.send(w.s.title, fmt.Sprintf("### %s\n\n```\n%s\n```", w.s.title, line))
+	return len(p), nil
+}
+
+// Shutdown 发送完队列中的日志，程序退出前调用
+func (w *LogWriter) Shutdown(ctx context.Context) error {
+	return w.s.queue.Shutdown(ctx)
+}
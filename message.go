@@ -125,6 +125,7 @@ type Link struct {
 	//移动端 在钉钉客户端内打开。
 	//PC端 默认外部浏览器打开。
 	//希望在侧边栏打开，请参考消息链接说明 https://open.dingtalk.com/document/app/message-link-description?spm=ding_open_doc.document.0.0.316448e0uHvnQD#section-7w8-4c2-9az
+	//可以用 SidebarLink 生成。
 	MessageUrl string `json:"messageUrl"`
 }
 
@@ -236,6 +237,7 @@ type EntiretyActionCard struct {
 	//移动端  在钉钉客户端内打开。
 	//PC端  默认侧边栏打开。
 	//希望在外部浏览器打开，请参考消息链接说明 https://open.dingtalk.com/document/app/message-link-description?spm=ding_open_doc.document.0.0.316448e0uHvnQD#section-7w8-4c2-9az
+	//可以用 BrowserLink 生成。
 	SingleURL string `json:"singleURL"`
 }
 
@@ -311,6 +313,7 @@ type Btn struct {
 	//移动端    在钉钉客户端内打开。
 	//PC端    默认侧边栏打开。
 	//希望在外部浏览器打开，请参考消息链接说明 https://open.dingtalk.com/document/app/message-link-description?spm=ding_open_doc.document.0.0.316448e0uHvnQD#section-7w8-4c2-9az
+	//可以用 BrowserLink 生成。
 	ActionURL string `json:"actionURL"`
 }
 
@@ -0,0 +1,230 @@
+package ding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultLatencyBuckets 发送耗时直方图默认的桶，单位秒
+	DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// DefaultMetrics 默认的指标收集器，默认为nil不记录。程序启动时设置为 NewMetrics(nil) 后，
+	// 所有客户端的发送和 CacheTokenProvider 获取accessToken都会记录到这里，挂到 /metrics 即可：
+	//
+	//	ding.DefaultMetrics = ding.NewMetrics(nil)
+	//	http.Handle("/metrics", ding.DefaultMetrics)
+	DefaultMetrics *Metrics
+)
+
+// Metrics 发送指标，以 Prometheus 文本格式通过 http.Handler 暴露，不依赖 Prometheus 客户端库。
+// client标签为 WithName 设置的客户端名字，没有设置时为客户端的类型，见 SendInfo.Client。
+// 指标：
+//
+//	ding_messages_sent_total{client,msg_type}             发送成功的消息数
+//	ding_messages_failed_total{client,msg_type,code}      发送失败的消息数，code为钉钉错误码
+//	ding_messages_rate_limited_total{client}              被限流的消息数
+//	ding_send_retries_total{client}                       重试次数
+//	ding_send_duration_seconds{client}                    发送耗时直方图，包括重试
+//	ding_token_refreshes_total{app_key,result}            从钉钉获取accessToken的次数，result为success或error
+//	ding_token_refresh_duration_seconds{app_key}          获取accessToken的耗时直方图
+type Metrics struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var metricHelp = map[string]string{
+	"ding_messages_sent_total":            "Number of messages sent to DingTalk successfully.",
+	"ding_messages_failed_total":          "Number of messages failed to send to DingTalk, by DingTalk error code.",
+	"ding_messages_rate_limited_total":    "Number of messages rate limited by DingTalk.",
+	"ding_send_retries_total":             "Number of send retries.",
+	"ding_send_duration_seconds":          "Latency of sending a message to DingTalk, including retries.",
+	"ding_token_refreshes_total":          "Number of access token refreshes from DingTalk.",
+	"ding_token_refresh_duration_seconds": "Latency of refreshing an access token from DingTalk.",
+}
+
+// NewMetrics 创建指标收集器，buckets为耗时直方图的桶，为nil时使用 DefaultLatencyBuckets
+func NewMetrics(buckets []float64) *Metrics {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	return &Metrics{
+		buckets:    b,
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+// labels 生成排好序的标签字符串，如 client="a",msg_type="text"
+func labels(kv ...string) string {
+	parts := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, kv[i], v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (m *Metrics) add(name, lbs string, v float64) {
+	c, ok := m.counters[name]
+	if !ok {
+		c = map[string]float64{}
+		m.counters[name] = c
+	}
+	c[lbs] += v
+}
+
+func (m *Metrics) observe(name, lbs string, v float64) {
+	hs, ok := m.histograms[name]
+	if !ok {
+		hs = map[string]*histogram{}
+		m.histograms[name] = hs
+	}
+	h, ok := hs[lbs]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		hs[lbs] = h
+	}
+	for i, b := range m.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveSend 记录一次发送，可以直接作为 Hooks.AfterSend
+func (m *Metrics) ObserveSend(info *SendInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if info.Err == nil {
+		m.add("ding_messages_sent_total", labels("client", info.Client, "msg_type", info.MsgType), 1)
+	} else {
+		code := info.ErrCode
+		if code == "" {
+			code = "error"
+		}
+		m.add("ding_messages_failed_total", labels("client", info.Client, "msg_type", info.MsgType, "code", code), 1)
+		var de *Error
+		if errors.As(info.Err, &de) && de.IsRateLimited() {
+			m.add("ding_messages_rate_limited_total", labels("client", info.Client), 1)
+		}
+	}
+	if info.Retries > 0 {
+		m.add("ding_send_retries_total", labels("client", info.Client), float64(info.Retries))
+	}
+	m.observe("ding_send_duration_seconds", labels("client", info.Client), info.Latency.Seconds())
+}
+
+// ObserveTokenRefresh 记录一次从钉钉获取accessToken，可以直接作为 CacheTokenProvider.OnRefresh
+func (m *Metrics) ObserveTokenRefresh(appKey string, latency time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add("ding_token_refreshes_total", labels("app_key", appKey, "result", result), 1)
+	m.observe("ding_token_refresh_duration_seconds", labels("app_key", appKey), latency.Seconds())
+}
+
+// Hooks 返回记录发送指标的钩子，用于 WhClient、OtOClient、GroupClient 的 AddHooks
+func (m *Metrics) Hooks() Hooks {
+	return Hooks{AfterSend: m.ObserveSend}
+}
+
+// Instrument 给客户端的Pipeline加上发送指标，如 m.Instrument(&whClient.Pipeline)。
+// 设置了 DefaultMetrics 时发送指标已经记录到它，只有需要单独的收集器时才调用
+func (m *Metrics) Instrument(p *Pipeline) {
+	p.AddHooks(m.Hooks())
+}
+
+// InstrumentTokenProvider 记录这个TokenProvider从钉钉获取accessToken的指标，保留原来的OnRefresh。
+// 和 Instrument 一样，只有需要 DefaultMetrics 以外的收集器时才调用
+func (m *Metrics) InstrumentTokenProvider(p *CacheTokenProvider) {
+	prev := p.OnRefresh
+	p.OnRefresh = func(appKey string, latency time.Duration, err error) {
+		if prev != nil {
+			prev(appKey, latency, err)
+		}
+		m.ObserveTokenRefresh(appKey, latency, err)
+	}
+}
+
+// writeText 以 Prometheus 文本格式输出所有指标
+func (m *Metrics) writeText(w *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.counters))
+	for name := range m.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, metricHelp[name], name)
+		c := m.counters[name]
+		for _, lbs := range sortedKeys(c) {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, lbs, formatFloat(c[lbs]))
+		}
+	}
+
+	names = names[:0]
+	for name := range m.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, metricHelp[name], name)
+		hs := m.histograms[name]
+		for _, lbs := range sortedKeys(hs) {
+			h := hs[lbs]
+			for i, b := range m.buckets {
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, lbs, formatFloat(b), h.counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, lbs, h.count)
+			fmt.Fprintf(w, "%s_sum{%s} %s\n", name, lbs, formatFloat(h.sum))
+			fmt.Fprintf(w, "%s_count{%s} %d\n", name, lbs, h.count)
+		}
+	}
+}
+
+// ServeHTTP 实现 http.Handler，挂到 /metrics 给 Prometheus 抓取
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	m.writeText(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
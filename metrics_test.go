@@ -0,0 +1,109 @@
+package ding_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+func scrape(t *testing.T, m *ding.Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	if ding.DefaultMetrics != nil {
+		t.Fatal("DefaultMetrics is enabled by default")
+	}
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("secret-token-1", "")
+	srv.AddApp("appKey", "appSecret")
+
+	m := ding.NewMetrics([]float64{1})
+	ding.DefaultMetrics = m
+	defer func() { ding.DefaultMetrics = nil }()
+
+	named, err := srv.NewWhClient("secret-token-1", "", ding.WithName("ops-alert"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	named.SetRetry(1, time.Millisecond)
+	anonymous, err := srv.NewWhClient("secret-token-1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := srv.NewGroupClient("robot", "appKey", "appSecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = named.SendTextMsg("ok"); err != nil {
+		t.Fatal(err)
+	}
+	srv.InjectFault(dingtest.EndpointWebhook, 2, dingtest.Fault{Err: &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast"}})
+	if err = named.SendTextMsg("limited"); err == nil {
+		t.Fatal("want a rate limit error")
+	}
+	if err = anonymous.SendMarkdownMsg("title", "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, cid := range []string{"cid1", "cid2", "cid3"} {
+		if err = group.SendTextMsg("ok", cid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`ding_messages_sent_total{client="ops-alert",msg_type="text"} 1`,
+		`ding_messages_failed_total{client="ops-alert",msg_type="text",code="130101"} 1`,
+		`ding_messages_rate_limited_total{client="ops-alert"} 1`,
+		`ding_send_retries_total{client="ops-alert"} 1`,
+		`ding_send_duration_seconds_count{client="ops-alert"} 2`,
+		`ding_messages_sent_total{client="webhook",msg_type="markdown"} 1`,
+		`ding_messages_sent_total{client="group",msg_type="text"} 3`,
+		`ding_token_refreshes_total{app_key="appKey",result="success"} 1`,
+		`ding_send_duration_seconds_bucket{client="group",le="+Inf"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics do not contain %s:\n%s", want, out)
+		}
+	}
+	for _, leak := range []string{"secret", "cid1", "target="} {
+		if strings.Contains(out, leak) {
+			t.Errorf("metrics contain %q:\n%s", leak, out)
+		}
+	}
+}
+
+func TestMetricsInstrument(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ding.NewMetrics(nil)
+	m.Instrument(&c.Pipeline)
+
+	var info *ding.SendInfo
+	c.AddHooks(ding.Hooks{AfterSend: func(i *ding.SendInfo) { info = i }})
+	if err = c.SendTextMsg("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if info == nil || info.Client != "webhook" || info.Target != c.String() {
+		t.Errorf("info = %+v, want client webhook and target %s", info, c)
+	}
+	if out := scrape(t, m); !strings.Contains(out, `ding_messages_sent_total{client="webhook",msg_type="text"} 1`) {
+		t.Errorf("metrics:\n%s", out)
+	}
+}
@@ -0,0 +1,204 @@
+package ding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Middleware 发送中间件，可以在发送前后做任何事情，如修改消息、拦截发送、记录日志。
+// 每次发送时都会调用中间件组装发送链，有状态的中间件需要把状态放在返回的函数之外。
+// 每一层的next都可以用 TargetName、TargetKey 拿到真正的发送目标
+type Middleware func(next Sender) Sender
+
+// SendInfo 一次发送的信息，传给 Hooks
+type SendInfo struct {
+	// 发送目标的名字，见 TargetName，包含群id、用户等，只用于日志
+	Target string
+	// 客户端的名字，见 WithName，没有设置时为客户端的类型：webhook、group、oto、worknotice。
+	// 取值有限，可以作为指标的标签
+	Client string
+	// 消息类型
+	MsgType string
+	// 开始发送的时间
+	Start time.Time
+	// 发送耗时，包括重试，只在 AfterSend 中有值
+	Latency time.Duration
+	// 重试次数，只在 AfterSend 中有值
+	Retries int
+	// 钉钉返回的错误码，见 ErrorCode，只在 AfterSend 中有值
+	ErrCode string
+	// 发送的错误，只在 AfterSend 中有值
+	Err error
+}
+
+// Hooks 每次发送前后的钩子，用于日志、链路追踪和指标
+type Hooks struct {
+	// 发送前调用
+	BeforeSend func(info *SendInfo)
+	// 发送完成后调用，包括重试
+	AfterSend func(info *SendInfo)
+}
+
+// Pipeline 客户端的发送流程：先依次经过中间件，再调用钩子，最后发送到钉钉，失败时按配置重试。
+// WhClient 和 IClient 都内嵌了 Pipeline，需要在开始发送前配置好
+type Pipeline struct {
+	// 客户端的名字，见 WithName
+	name         string
+	middlewares  []Middleware
+	hooks        []Hooks
+	maxRetries   int
+	retryBackoff time.Duration
+}
+
+// Use 添加中间件，先添加的在外层
+func (p *Pipeline) Use(mws ...Middleware) {
+	p.middlewares = append(p.middlewares, mws...)
+}
+
+// AddHooks 添加发送前后的钩子
+func (p *Pipeline) AddHooks(h Hooks) {
+	p.hooks = append(p.hooks, h)
+}
+
+// SetRetry 被限流或连接钉钉失败时最多重试maxRetries次，第n次重试前等待n*backoff，
+// 消息的 Message.Context 取消时不再等待，返回同时包装了ctx错误和最后一次发送错误的错误。请求已经发出后的超时等错误不重试，避免重复发送
+func (p *Pipeline) SetRetry(maxRetries int, backoff time.Duration) {
+	p.maxRetries = maxRetries
+	p.retryBackoff = backoff
+}
+
+// namedSender 带名字和唯一标识的Sender，让中间件中的 TargetName、TargetKey 能拿到真正的发送目标
+type namedSender struct {
+	name string
+	key  string
+	SenderFunc
+}
+
+func (s namedSender) String() string {
+	return s.name
+}
+
+func (s namedSender) TargetKey() string {
+	return s.key
+}
+
+// send 经过中间件、钩子和重试后调用do发送到钉钉，kind为客户端的类型，target为发送目标，用于中间件和钩子区分目标
+func (p *Pipeline) send(kind string, target Sender, msg *Message, do func(msg *Message) error) error {
+	name, key := TargetName(target), TargetKey(target)
+	client := p.name
+	if client == "" {
+		client = kind
+	}
+	var s Sender = namedSender{name: name, key: key, SenderFunc: func(m *Message) error {
+		return p.do(client, name, m, do)
+	}}
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		s = namedSender{name: name, key: key, SenderFunc: p.middlewares[i](s).Send}
+	}
+	return s.Send(msg)
+}
+
+func (p *Pipeline) do(client, target string, msg *Message, do func(msg *Message) error) error {
+	info := &SendInfo{Target: target, Client: client, MsgType: msg.MsgType, Start: time.Now()}
+	for _, h := range p.hooks {
+		if h.BeforeSend != nil {
+			h.BeforeSend(info)
+		}
+	}
+
+	var err error
+	for {
+		err = do(msg)
+		if err == nil || info.Retries >= p.maxRetries || !retryable(err) {
+			break
+		}
+		info.Retries++
+		if ctxErr := sleepContext(msg.Context(), time.Duration(info.Retries)*p.retryBackoff); ctxErr != nil {
+			// 保留最后一次发送的错误，调用者和指标仍然能拿到钉钉的错误码
+			err = fmt.Errorf("%w (last error: %w)", ctxErr, err)
+			break
+		}
+	}
+
+	info.Latency = time.Since(info.Start)
+	info.Err = err
+	info.ErrCode = ErrorCode(err)
+	if m := DefaultMetrics; m != nil {
+		m.ObserveSend(info)
+	}
+	for _, h := range p.hooks {
+		if h.AfterSend != nil {
+			h.AfterSend(info)
+		}
+	}
+	return err
+}
+
+// sleepContext 等待d，ctx先结束时返回ctx的错误
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable 被限流或连接钉钉失败（请求还没有发出）时可以重试
+func retryable(err error) bool {
+	var de *Error
+	if errors.As(err, &de) {
+		return de.IsRateLimited()
+	}
+	var oe *net.OpError
+	if errors.As(err, &oe) && oe.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// ErrorCode 钉钉的错误码：新版接口的code，webhook和旧版接口的errcode，都没有时为 http_状态码。
+// 不是钉钉返回的错误时为空字符串
+func ErrorCode(err error) string {
+	var de *Error
+	if !errors.As(err, &de) {
+		return ""
+	}
+	switch {
+	case de.Code != "":
+		return de.Code
+	case de.ErrCode != 0:
+		return strconv.Itoa(de.ErrCode)
+	default:
+		return "http_" + strconv.Itoa(de.StatusCode)
+	}
+}
+
+// LogHooks 用 slog 记录每次发送结果的钩子，成功为Debug级别，失败为Error级别
+func LogHooks(logger *slog.Logger) Hooks {
+	return Hooks{
+		AfterSend: func(info *SendInfo) {
+			level := slog.LevelDebug
+			if info.Err != nil {
+				level = slog.LevelError
+			}
+			logger.Log(context.Background(), level, "send ding message",
+				"client", info.Client,
+				"target", info.Target,
+				"msgType", info.MsgType,
+				"latency", info.Latency,
+				"retries", info.Retries,
+				"errCode", info.ErrCode,
+				"err", info.Err,
+			)
+		},
+	}
+}
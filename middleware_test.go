@@ -0,0 +1,46 @@
+package ding_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+func TestRetryCanceledKeepsLastError(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetRetry(3, time.Minute)
+	var info *ding.SendInfo
+	c.AddHooks(ding.Hooks{AfterSend: func(i *ding.SendInfo) { info = i }})
+	srv.InjectFault(dingtest.EndpointWebhook, 1, dingtest.Fault{Err: &ding.Error{ErrCode: ding.ErrCodeSendTooFast, ErrMsg: "send too fast"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = c.Send(ding.NewTextMessage("hello").WithContext(ctx))
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("backoff did not stop when the context was done")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	var de *ding.Error
+	if !errors.As(err, &de) || !de.IsRateLimited() {
+		t.Errorf("err = %v, want the last DingTalk error to be kept", err)
+	}
+	if info == nil || info.Retries != 1 || info.ErrCode != "130101" {
+		t.Errorf("info = %+v, want 1 retry with errcode 130101", info)
+	}
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("recorded %d messages, want 0", n)
+	}
+}
@@ -0,0 +1,194 @@
+package ding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	// DefaultInterfaceTimeout 接口方式没有设置 WithHTTPClient 时请求钉钉的超时时间
+	DefaultInterfaceTimeout = 2 * time.Second
+
+	// ErrInvalidClientConfig 创建客户端的参数不合法
+	ErrInvalidClientConfig = errors.New("ding: invalid client config")
+)
+
+// Option 创建客户端的选项，用于 NewWhClientWithOptions、NewOtOClientWithOptions、NewGroupClientWithOptions。
+// 每个客户端有自己的配置，多个不同配置的客户端可以同时使用，互不影响
+type Option func(o *clientOptions)
+
+type clientOptions struct {
+	httpClient     *http.Client
+	logger         *log.Logger
+	debug          bool
+	endpoint       string
+	accessTokenUrl string
+	apiBaseUrl     string
+	oapiBaseUrl    string
+	tokenProvider  TokenProvider
+	deduper        *Deduper
+	name           string
+}
+
+// WithHTTPClient 请求钉钉使用的 http.Client，用于设置超时、代理等。接口方式获取accessToken也使用它
+func WithHTTPClient(hc *http.Client) Option {
+	return func(o *clientOptions) {
+		o.httpClient = hc
+	}
+}
+
+// WithLogger debug输出和发送失败等日志使用的logger，为nil时使用标准库 log 包
+func WithLogger(l *log.Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = l
+	}
+}
+
+// WithDebug 只对这个客户端开启debug，输出钉钉返回的消息
+func WithDebug(debug bool) Option {
+	return func(o *clientOptions) {
+		o.debug = debug
+	}
+}
+
+// WithEndpoint 发送消息的接口地址：webhook客户端为不含access_token的webhook地址，
+// 单聊客户端为批量发送单聊消息的地址，群聊客户端为发送群聊消息的地址。用于代理或测试
+func WithEndpoint(url string) Option {
+	return func(o *clientOptions) {
+		o.endpoint = url
+	}
+}
+
+// WithAccessTokenUrl 获取accessToken的地址，只对接口方式有效，设置后客户端使用单独的accessToken缓存
+func WithAccessTokenUrl(url string) Option {
+	return func(o *clientOptions) {
+		o.accessTokenUrl = url
+	}
+}
+
+// WithBaseUrl 新版接口(api.dingtalk.com)和旧版接口(oapi.dingtalk.com)的地址，为空时使用默认地址。
+// 通讯录等接口的地址都由它们加上接口路径得到，只对接口方式有效。用于代理或测试，如 dingtest.Server.Options
+func WithBaseUrl(apiBaseUrl, oapiBaseUrl string) Option {
+	return func(o *clientOptions) {
+		o.apiBaseUrl = apiBaseUrl
+		o.oapiBaseUrl = oapiBaseUrl
+	}
+}
+
+// WithTokenProvider 获取accessToken的方式，只对接口方式有效，优先于 WithAccessTokenUrl
+func WithTokenProvider(tp TokenProvider) Option {
+	return func(o *clientOptions) {
+		o.tokenProvider = tp
+	}
+}
+
+// WithDeduper 在客户端的发送流程中安装重复消息抑制，客户端的所有发送方法和Sender都会经过它。
+// d可以用 NewDeduper(nil, window, key) 创建，多个客户端共用时每个发送目标单独去重
+func WithDeduper(d *Deduper) Option {
+	return func(o *clientOptions) {
+		o.deduper = d
+	}
+}
+
+// WithName 客户端的名字，用于指标的client标签和日志，如 "ops-alert"。
+// 没有设置时使用客户端的类型，指标中不会出现token、群id、用户等取值无限的标签
+func WithName(name string) Option {
+	return func(o *clientOptions) {
+		o.name = name
+	}
+}
+
+func newClientOptions(opts []Option) *clientOptions {
+	o := &clientOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// installPipeline 把选项中的中间件安装到客户端的发送流程，创建客户端时调用一次
+func (o *clientOptions) installPipeline(p *Pipeline) {
+	p.name = o.name
+	if o.deduper != nil {
+		p.Use(o.deduper.Middleware())
+	}
+}
+
+// transport 客户端请求钉钉的http配置，零值使用包级的默认配置
+type transport struct {
+	httpClient *http.Client
+	logger     *log.Logger
+	debug      bool
+}
+
+func (t *transport) setOptions(o *clientOptions) {
+	t.httpClient = o.httpClient
+	t.logger = o.logger
+	t.debug = o.debug
+}
+
+// client 设置了 WithHTTPClient 时使用它，否则使用def
+func (t *transport) client(def *http.Client) *http.Client {
+	if t.httpClient != nil {
+		return t.httpClient
+	}
+	return def
+}
+
+// logf 写日志，没有设置logger时使用标准库 log 包
+func (t *transport) logf(format string, args ...any) {
+	if t.logger != nil {
+		t.logger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// debugf 开启debug时写日志，客户端的debug和包级的 Debug 有一个开启即可
+func (t *transport) debugf(format string, args ...any) {
+	if t.debug || Debug {
+		t.logf(format, args...)
+	}
+}
+
+// postJson 以json格式post msg到url，返回状态码和回复
+func (t *transport) postJson(hc *http.Client, url string, header http.Header, msg any) (int, []byte, error) {
+	return t.doJson(hc, http.MethodPost, url, header, msg)
+}
+
+// doJson 以json格式发送请求，msg为nil时没有请求体，返回状态码和回复
+func (t *transport) doJson(hc *http.Client, method, url string, header http.Header, msg any) (int, []byte, error) {
+	var body io.Reader
+	if msg != nil {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return 0, nil, err
+		}
+		body = bytes.NewBuffer(b)
+	}
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	request.Header.Set("Content-Type", ContentTypeJson)
+
+	resp, err := hc.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	respByte, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, respByte, nil
+}
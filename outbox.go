@@ -0,0 +1,469 @@
+package ding
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultOutboxCompactThreshold 已发送的记录达到这个数量后压缩日志
+	DefaultOutboxCompactThreshold = 1000
+	// DefaultOutboxMaxAttempts 每条消息默认最多尝试发送的次数
+	DefaultOutboxMaxAttempts = 10
+	// DefaultOutboxRetryInterval Start 的interval<=0时后台重试的间隔
+	DefaultOutboxRetryInterval = time.Minute
+
+	// ErrOutboxClosed outbox已经关闭
+	ErrOutboxClosed = errors.New("ding: outbox is closed")
+
+	outboxOpAdd  = "add"
+	outboxOpFail = "fail"
+	outboxOpDone = "done"
+	outboxOpDrop = "drop"
+)
+
+// outboxRecord 日志中的一行记录
+type outboxRecord struct {
+	Op     string   `json:"op"`
+	Id     uint64   `json:"id"`
+	Target string   `json:"target,omitempty"`
+	Msg    *Message `json:"msg,omitempty"`
+	// 消息的内容原样发送，见 NewMessageFromWhMsg
+	RawText bool `json:"rawText,omitempty"`
+	// 已经尝试发送的次数，fail记录和压缩后的add记录中有值
+	Attempts int       `json:"attempts,omitempty"`
+	Time     time.Time `json:"time,omitempty"`
+}
+
+// newOutboxAddRecord 保存消息的记录，消息的上下文不能持久化，后台重试和重启后重放都使用 context.Background()
+func newOutboxAddRecord(id uint64, target string, msg *Message) *outboxRecord {
+	m := *msg
+	m.ctx = nil
+	return &outboxRecord{Op: outboxOpAdd, Id: id, Target: target, Msg: &m, RawText: m.rawText, Time: time.Now()}
+}
+
+type outboxEntry struct {
+	record   *outboxRecord
+	attempts int
+	inflight bool
+}
+
+// Outbox 持久化的发件箱，保证消息至少发送一次。
+// 每条消息在发送前先追加写入本地日志文件，钉钉返回成功后再标记为已发送，发送失败时记录尝试的次数；
+// 程序重启后会重新发送没有标记为已发送的消息，已发送的记录会定期压缩掉。
+// 消息的 Message.Context 只用于第一次发送，不会持久化，取消它不影响后台重试
+type Outbox struct {
+	path    string
+	resolve func(target string) (Sender, error)
+
+	// 每条消息最多尝试发送的次数，超过后移到死信文件，见 Outbox.DeadLetters，<=0时使用 DefaultOutboxMaxAttempts
+	MaxAttempts int
+	// 已发送的记录达到这个数量后压缩日志，<=0时使用 DefaultOutboxCompactThreshold
+	CompactThreshold int
+
+	mu       sync.Mutex
+	f        *os.File
+	entries  map[uint64]*outboxEntry
+	nextId   uint64
+	garbage  int
+	closed   bool
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewOutbox 打开或创建日志文件path，加载其中还没有发送成功的消息。
+// resolve 根据目标名字获取Sender，如 Registry.Sender。调用 Start 后开始重新发送之前没有发送成功的消息
+func NewOutbox(path string, resolve func(target string) (Sender, error)) (*Outbox, error) {
+	o := &Outbox{
+		path:    path,
+		resolve: resolve,
+		entries: map[uint64]*outboxEntry{},
+		nextId:  1,
+		stop:    make(chan struct{}),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	o.f = f
+	return o, nil
+}
+
+// load 读取日志，恢复还没有发送成功的消息
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var r outboxRecord
+		// 崩溃时最后一行可能没有写完整，跳过
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			continue
+		}
+		if r.Id >= o.nextId {
+			o.nextId = r.Id + 1
+		}
+		switch r.Op {
+		case outboxOpAdd:
+			if r.Msg == nil {
+				continue
+			}
+			r.Msg.rawText = r.RawText
+			o.entries[r.Id] = &outboxEntry{record: &r, attempts: r.Attempts}
+		case outboxOpFail:
+			if e, ok := o.entries[r.Id]; ok {
+				e.attempts = r.Attempts
+			}
+			o.garbage++
+		case outboxOpDone, outboxOpDrop:
+			delete(o.entries, r.Id)
+			o.garbage++
+		}
+	}
+	return sc.Err()
+}
+
+// write 追加一行记录，调用时需要持有锁
+func (o *Outbox) write(r *outboxRecord, fsync bool) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = o.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if fsync {
+		return o.f.Sync()
+	}
+	return nil
+}
+
+// Send 把消息写入日志后立即尝试发送到名为target的目标。
+// 写入日志失败时返回错误，消息没有被接收；发送失败时也返回错误，但消息已经保存，会在后台重试
+func (o *Outbox) Send(target string, msg *Message) error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return ErrOutboxClosed
+	}
+	r := newOutboxAddRecord(o.nextId, target, msg)
+	if err := o.write(r, true); err != nil {
+		o.mu.Unlock()
+		return fmt.Errorf("write ding outbox failed: %w", err)
+	}
+	o.nextId++
+	e := &outboxEntry{record: r, inflight: true}
+	o.entries[r.Id] = e
+	o.mu.Unlock()
+
+	return o.deliver(e, msg)
+}
+
+// Sender 返回发送到名为target的目标的Sender，经过outbox保证至少发送一次
+func (o *Outbox) Sender(target string) Sender {
+	return &outboxSender{outbox: o, target: target}
+}
+
+type outboxSender struct {
+	outbox *Outbox
+	target string
+}
+
+func (s *outboxSender) Send(msg *Message) error {
+	return s.outbox.Send(s.target, msg)
+}
+
+func (s *outboxSender) String() string {
+	return "outbox:" + s.target
+}
+
+// deliver 发送一条消息，调用前需要把entry标记为inflight。msg为 Send 传入的消息，后台重试时为日志中保存的消息
+func (o *Outbox) deliver(e *outboxEntry, msg *Message) error {
+	r := e.record
+	s, err := o.resolve(r.Target)
+	if err == nil {
+		err = s.Send(msg)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.inflight = false
+	e.attempts++
+	maxAttempts := o.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultOutboxMaxAttempts
+	}
+	switch {
+	case err == nil:
+		o.finish(r.Id, outboxOpDone)
+	case e.attempts >= maxAttempts:
+		// 写入死信失败时保留消息，下次重试时再移
+		if derr := o.writeDeadLetter(e, err); derr != nil {
+			log.Println("write ding outbox dead letter failed: " + derr.Error())
+			o.recordAttempts(e)
+			break
+		}
+		log.Printf("move ding outbox message %d to %s to dead letters after %d attempts: %s\n", r.Id, r.Target, e.attempts, err)
+		o.finish(r.Id, outboxOpDrop)
+	default:
+		o.recordAttempts(e)
+	}
+	return err
+}
+
+// recordAttempts 记录发送失败的次数，重启后继续计数，超过最大次数的消息最终会移到死信。调用时需要持有锁
+func (o *Outbox) recordAttempts(e *outboxEntry) {
+	if o.closed {
+		return
+	}
+	// 记录失败只会多尝试几次
+	if err := o.write(&outboxRecord{Op: outboxOpFail, Id: e.record.Id, Attempts: e.attempts}, false); err != nil {
+		log.Println("write ding outbox failed: " + err.Error())
+	}
+	o.garbage++
+}
+
+// DeadLetter 超过最大尝试次数仍然发送失败的消息
+type DeadLetter struct {
+	Id     uint64   `json:"id"`
+	Target string   `json:"target"`
+	Msg    *Message `json:"msg"`
+	// 消息的内容原样发送，读取时已经设置到Msg中
+	RawText  bool      `json:"rawText,omitempty"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts"`
+	// 最后一次发送的错误
+	Err string `json:"err"`
+	// 移到死信的时间
+	DeadAt time.Time `json:"deadAt"`
+}
+
+// deadLetterPath 死信文件，和日志文件在同一个目录
+func (o *Outbox) deadLetterPath() string {
+	return o.path + ".dead"
+}
+
+// writeDeadLetter 追加一条死信，调用时需要持有锁
+func (o *Outbox) writeDeadLetter(e *outboxEntry, sendErr error) error {
+	r := e.record
+	b, err := json.Marshal(&DeadLetter{
+		Id:       r.Id,
+		Target:   r.Target,
+		Msg:      r.Msg,
+		RawText:  r.RawText,
+		Time:     r.Time,
+		Attempts: e.attempts,
+		Err:      sendErr.Error(),
+		DeadAt:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.deadLetterPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// DeadLetters 读取所有的死信，用于人工排查或重新发送
+func (o *Outbox) DeadLetters() ([]*DeadLetter, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f, err := os.Open(o.deadLetterPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res []*DeadLetter
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var d DeadLetter
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			continue
+		}
+		if d.Msg != nil {
+			d.Msg.rawText = d.RawText
+		}
+		res = append(res, &d)
+	}
+	return res, sc.Err()
+}
+
+// finish 标记消息已发送或已丢弃，调用时需要持有锁
+func (o *Outbox) finish(id uint64, op string) {
+	delete(o.entries, id)
+	if o.closed {
+		return
+	}
+	// 标记失败只会导致重复发送，不影响至少发送一次
+	if err := o.write(&outboxRecord{Op: op, Id: id}, false); err != nil {
+		log.Println("write ding outbox failed: " + err.Error())
+	}
+	o.garbage++
+}
+
+// Pending 还没有发送成功的消息数量
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Retry 重新发送所有还没有发送成功的消息，按写入顺序发送
+func (o *Outbox) Retry() {
+	o.mu.Lock()
+	var todo []*outboxEntry
+	for _, e := range o.entries {
+		if !e.inflight {
+			e.inflight = true
+			todo = append(todo, e)
+		}
+	}
+	o.mu.Unlock()
+
+	sort.Slice(todo, func(i, j int) bool { return todo[i].record.Id < todo[j].record.Id })
+	for _, e := range todo {
+		if err := o.deliver(e, e.record.Msg); err != nil {
+			log.Printf("retry ding outbox message %d to %s failed: %s\n", e.record.Id, e.record.Target, err)
+		}
+	}
+}
+
+// Compact 压缩日志，只保留还没有发送成功的消息
+func (o *Outbox) Compact() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return ErrOutboxClosed
+	}
+	return o.compact()
+}
+
+// compact 把还没有发送成功的消息写到临时文件，再替换日志文件。
+// 临时文件以追加方式打开，改名后直接作为新的日志文件继续写入，不需要重新打开，
+// 失败时临时文件被删除，继续使用原来的日志文件。调用时需要持有锁
+func (o *Outbox) compact() error {
+	ids := make([]uint64, 0, len(o.entries))
+	for id := range o.entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		e := o.entries[id]
+		r := *e.record
+		r.Attempts = e.attempts
+		b, err := json.Marshal(&r)
+		if err != nil {
+			return fail(err)
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, o.path)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	o.f.Close()
+	o.f = f
+	o.garbage = 0
+	return nil
+}
+
+// Start 在后台立即重新发送之前没有发送成功的消息，之后每隔interval重试一次，interval<=0时使用 DefaultOutboxRetryInterval，
+// 已发送的记录达到 CompactThreshold 后压缩日志
+func (o *Outbox) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultOutboxRetryInterval
+	}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			o.Retry()
+			o.maybeCompact()
+			select {
+			case <-o.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (o *Outbox) maybeCompact() {
+	threshold := o.CompactThreshold
+	if threshold <= 0 {
+		threshold = DefaultOutboxCompactThreshold
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed || o.garbage < threshold {
+		return
+	}
+	if err := o.compact(); err != nil {
+		log.Println("compact ding outbox failed: " + err.Error())
+	}
+}
+
+// Close 停止后台重试并关闭日志文件，没有发送成功的消息会在下次打开时重新发送
+func (o *Outbox) Close() error {
+	o.stopOnce.Do(func() { close(o.stop) })
+	o.wg.Wait()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	return o.f.Close()
+}
@@ -0,0 +1,198 @@
+package ding_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+var errTargetDown = errors.New("target is down")
+
+// outboxEnv 假服务和发送到它的目标，down为true时目标不可用
+type outboxEnv struct {
+	srv  *dingtest.Server
+	c    *ding.WhClient
+	path string
+
+	mu   sync.Mutex
+	down bool
+}
+
+func newOutboxEnv(t *testing.T) *outboxEnv {
+	t.Helper()
+	srv := dingtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &outboxEnv{srv: srv, c: c, path: filepath.Join(t.TempDir(), "outbox.log")}
+}
+
+func (env *outboxEnv) setDown(down bool) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.down = down
+}
+
+func (env *outboxEnv) resolve(target string) (ding.Sender, error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.down || target != "ops" {
+		return nil, errTargetDown
+	}
+	return env.c, nil
+}
+
+func (env *outboxEnv) open(t *testing.T) *ding.Outbox {
+	t.Helper()
+	o, err := ding.NewOutbox(env.path, env.resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestOutboxReplayAfterRestart(t *testing.T) {
+	env := newOutboxEnv(t)
+	env.setDown(true)
+	o := env.open(t)
+	msg, err := ding.NewMessageFromWhMsg(ding.NewWhTextMsgWithAtUserIds("deploy done", "u1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = o.Send("ops", msg.WithContext(ctx)); !errors.Is(err, errTargetDown) {
+		t.Fatalf("err = %v, want errTargetDown", err)
+	}
+	if err = o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	env.setDown(false)
+	o = env.open(t)
+	defer o.Close()
+	if n := o.Pending(); n != 1 {
+		t.Fatalf("pending = %d after restart, want 1", n)
+	}
+	o.Start(0)
+	for i := 0; i < 100 && o.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msgs := env.srv.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(msgs))
+	}
+	// NewMessageFromWhMsg 的内容原样发送，重放时不能补上 @u1
+	if msgs[0].Text != "deploy done" || len(msgs[0].At.AtUserIds) != 1 {
+		t.Errorf("replayed %q at %v, want %q at [u1]", msgs[0].Text, msgs[0].At.AtUserIds, "deploy done")
+	}
+}
+
+func TestOutboxDeadLetterAcrossRestarts(t *testing.T) {
+	env := newOutboxEnv(t)
+	env.setDown(true)
+
+	o := env.open(t)
+	o.MaxAttempts = 3
+	_ = o.Send("ops", ding.NewTextMessage("poison"))
+	_ = o.Close()
+	for i := 0; i < 2; i++ {
+		o = env.open(t)
+		o.MaxAttempts = 3
+		o.Retry()
+		_ = o.Close()
+	}
+
+	o = env.open(t)
+	defer o.Close()
+	if n := o.Pending(); n != 0 {
+		t.Errorf("pending = %d, want the message moved to dead letters", n)
+	}
+	dead, err := o.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].Msg.Text != "poison" || !strings.Contains(dead[0].Err, errTargetDown.Error()) {
+		t.Fatalf("dead letters = %+v, want the poison message after 3 attempts", dead)
+	}
+}
+
+func TestOutboxCompact(t *testing.T) {
+	env := newOutboxEnv(t)
+	o := env.open(t)
+	o.MaxAttempts = 5
+	for i := 0; i < 5; i++ {
+		if err := o.Send("ops", ding.NewTextMessage("ok")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.setDown(true)
+	_ = o.Send("ops", ding.NewTextMessage("pending"))
+	if err := o.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(env.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 1 || !strings.Contains(string(b), `"attempts":1`) {
+		t.Fatalf("log after compact:\n%s", b)
+	}
+
+	// 压缩后继续写入新的日志文件
+	_ = o.Send("ops", ding.NewTextMessage("after compact"))
+	_ = o.Close()
+	o = env.open(t)
+	o.MaxAttempts = 5
+	defer o.Close()
+	if n := o.Pending(); n != 2 {
+		t.Fatalf("pending = %d after reopen, want 2", n)
+	}
+	env.setDown(false)
+	o.Retry()
+	if n := len(env.srv.Messages()); n != 7 {
+		t.Errorf("delivered %d messages, want 7", n)
+	}
+}
+
+func TestOutboxConcurrent(t *testing.T) {
+	env := newOutboxEnv(t)
+	o := env.open(t)
+	defer o.Close()
+	o.CompactThreshold = 10
+	o.Start(time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				env.setDown((i+j)%3 == 0)
+				_ = o.Send("ops", ding.NewTextMessage("hello"))
+			}
+		}(i)
+	}
+	wg.Wait()
+	env.setDown(false)
+	for i := 0; i < 200 && o.Pending() > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := o.Pending(); n != 0 {
+		t.Fatalf("pending = %d, want 0", n)
+	}
+	if n := len(env.srv.Messages()); n != 80 {
+		t.Errorf("delivered %d messages, want 80", n)
+	}
+}
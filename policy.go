@@ -0,0 +1,229 @@
+// 机器人命令的权限策略，根据 PostReq 中的发送者和会话判断是否可以执行命令，
+// 命令的策略对子命令同样生效，没有通过时回复拒绝的消息，并记录审计。
+// 策略判断的 IsAdmin、SenderStaffId、ConversationId 等字段都来自请求体，只有校验了签名才可信：
+// 设置了策略而 Router.Secret 为空时 Router.ServeHTTP 拒绝所有请求，自己调用 Router.Dispatch 时需要先校验签名
+// 用法：
+//
+//	r.Command("deploy", "部署服务", nil).Policy(ding.RequireAdmin(), ding.AllowConversations(opsGroupId))
+
+package ding
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrPermissionDenied 没有权限执行命令
+	ErrPermissionDenied = errors.New("ding: permission denied")
+	// ErrPolicyWithoutSecret 设置了权限策略但没有设置 Router.Secret，无法校验请求是否来自钉钉
+	ErrPolicyWithoutSecret = errors.New("ding: router has policies but no secret to verify requests")
+)
+
+// Policy 权限策略，返回nil时允许，否则返回拒绝的原因，一般包装了 ErrPermissionDenied
+type Policy func(req *PostReq) error
+
+func denied(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrPermissionDenied, fmt.Sprintf(format, a...))
+}
+
+// RequireAdmin 只允许群管理员
+func RequireAdmin() Policy {
+	return func(req *PostReq) error {
+		if !req.IsAdmin {
+			return denied("只有管理员可以执行")
+		}
+		return nil
+	}
+}
+
+// AllowStaff 只允许userId在staffIds中的员工
+func AllowStaff(staffIds ...string) Policy {
+	set := stringSet(staffIds)
+	return func(req *PostReq) error {
+		if req.SenderStaffId == "" || !set[req.SenderStaffId] {
+			return denied("不在允许的人员列表中")
+		}
+		return nil
+	}
+}
+
+// AllowCorp 只允许corpIds中的企业的员工，用于排除互通群中的外部成员
+func AllowCorp(corpIds ...string) Policy {
+	set := stringSet(corpIds)
+	return func(req *PostReq) error {
+		if req.SenderCorpId == "" || !set[req.SenderCorpId] {
+			return denied("不是允许的企业的成员")
+		}
+		return nil
+	}
+}
+
+// OnlyDan 只允许单聊
+func OnlyDan() Policy {
+	return conversationType(Dan, "单聊")
+}
+
+// OnlyQun 只允许群聊
+func OnlyQun() Policy {
+	return conversationType(Qun, "群聊")
+}
+
+func conversationType(t, name string) Policy {
+	return func(req *PostReq) error {
+		if req.ConversationType != t {
+			return denied("只能在%s中执行", name)
+		}
+		return nil
+	}
+}
+
+// AllowConversations 只允许在conversationIds这些会话中执行
+func AllowConversations(conversationIds ...string) Policy {
+	set := stringSet(conversationIds)
+	return func(req *PostReq) error {
+		if !set[req.ConversationId] {
+			return denied("不能在这个会话中执行")
+		}
+		return nil
+	}
+}
+
+// AnyOf 满足任意一个策略即允许，如管理员或者值班人员，都不满足时返回第一个拒绝的原因
+func AnyOf(policies ...Policy) Policy {
+	return func(req *PostReq) error {
+		var first error
+		for _, p := range policies {
+			err := p(req)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if first == nil {
+			return denied("没有可以满足的策略")
+		}
+		return first
+	}
+}
+
+// AllOf 需要满足所有的策略，返回第一个拒绝的原因
+func AllOf(policies ...Policy) Policy {
+	return func(req *PostReq) error {
+		for _, p := range policies {
+			if err := p(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func stringSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// DeniedRecord 被拒绝的命令的审计记录
+type DeniedRecord struct {
+	// 时间
+	Time time.Time `json:"time"`
+	// 命令，如 "deploy rollback"
+	Command string `json:"command"`
+	// 去掉@机器人之后的消息内容
+	Text string `json:"text"`
+	// 拒绝的原因
+	Reason string `json:"reason"`
+	// 发送者的userId
+	SenderStaffId string `json:"senderStaffId,omitempty"`
+	// 发送者昵称
+	SenderNick string `json:"senderNick"`
+	// 发送者的企业corpId
+	SenderCorpId string `json:"senderCorpId,omitempty"`
+	// 是否为管理员
+	IsAdmin bool `json:"isAdmin"`
+	// 会话id
+	ConversationId string `json:"conversationId"`
+	// 1单聊 2群聊
+	ConversationType string `json:"conversationType"`
+	// 群名称
+	ConversationTitle string `json:"conversationTitle,omitempty"`
+}
+
+func newDeniedRecord(c *Command, req *PostReq, text string, err error) *DeniedRecord {
+	return &DeniedRecord{
+		Time:              time.Now(),
+		Command:           c.Path(),
+		Text:              text,
+		Reason:            strings.TrimPrefix(err.Error(), ErrPermissionDenied.Error()+": "),
+		SenderStaffId:     req.SenderStaffId,
+		SenderNick:        req.SenderNick,
+		SenderCorpId:      req.SenderCorpId,
+		IsAdmin:           req.IsAdmin,
+		ConversationId:    req.ConversationId,
+		ConversationType:  req.ConversationType,
+		ConversationTitle: req.ConversationTitle,
+	}
+}
+
+// Policy 设置命令的权限策略，需要满足所有的策略，对子命令同样生效
+func (c *Command) Policy(policies ...Policy) *Command {
+	c.policies = append(c.policies, policies...)
+	return c
+}
+
+// hasPolicies 命令或者子命令是否设置了权限策略
+func (c *Command) hasPolicies() bool {
+	if len(c.policies) > 0 {
+		return true
+	}
+	for _, sub := range c.subs {
+		if sub.hasPolicies() {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy 设置所有命令的权限策略，help不受限制
+func (r *Router) Policy(policies ...Policy) *Router {
+	r.root.Policy(policies...)
+	return r
+}
+
+// authorize 从根命令到c依次检查权限策略，拒绝时回复并记录审计，返回是否允许。
+// 拒绝不是错误，只交给 OnDenied，返回的error只有回复失败的错误
+func (r *Router) authorize(c *Command, req *PostReq, text string) (bool, error) {
+	var chain []*Command
+	for p := c; p != nil; p = p.parent {
+		chain = append([]*Command{p}, chain...)
+	}
+	for _, cmd := range chain {
+		for _, p := range cmd.policies {
+			err := p(req)
+			if err == nil {
+				continue
+			}
+			rec := newDeniedRecord(c, req, text, err)
+			if r.OnDenied != nil {
+				r.OnDenied(rec)
+			}
+			msg := NewMarkdownMessage("无权限", fmt.Sprintf("无权限执行 `%s`：%s", rec.Command, rec.Reason))
+			if r.DenyReply != nil {
+				msg = r.DenyReply(rec)
+			}
+			if msg != nil {
+				return false, r.reply(req, msg)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package ding
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCombinedPolicies(t *testing.T) {
+	admin := &PostReq{IsAdmin: true, SenderStaffId: "u2", ConversationId: "cid1"}
+	staff := &PostReq{SenderStaffId: "u1", ConversationId: "cid2"}
+	other := &PostReq{SenderStaffId: "u3", ConversationId: "cid1"}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		req    *PostReq
+		allow  bool
+		reason string
+	}{
+		{name: "any of, first allows", policy: AnyOf(RequireAdmin(), AllowStaff("u1")), req: admin, allow: true},
+		{name: "any of, second allows", policy: AnyOf(RequireAdmin(), AllowStaff("u1")), req: staff, allow: true},
+		{name: "any of, none allows", policy: AnyOf(RequireAdmin(), AllowStaff("u1")), req: other, reason: "只有管理员可以执行"},
+		{name: "any of, empty", policy: AnyOf(), req: admin, reason: "没有可以满足的策略"},
+		{name: "all of, all allow", policy: AllOf(RequireAdmin(), AllowConversations("cid1")), req: admin, allow: true},
+		{name: "all of, second denies", policy: AllOf(AllowStaff("u1"), AllowConversations("cid1")), req: staff, reason: "不能在这个会话中执行"},
+		{name: "all of, first denies", policy: AllOf(RequireAdmin(), AllowConversations("cid1")), req: other, reason: "只有管理员可以执行"},
+		{name: "all of, empty", policy: AllOf(), req: other, allow: true},
+		{name: "nested", policy: AllOf(AnyOf(RequireAdmin(), AllowStaff("u3")), AllowConversations("cid1")), req: other, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy(tt.req)
+			if tt.allow {
+				if err != nil {
+					t.Fatalf("err = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrPermissionDenied) {
+				t.Fatalf("err = %v, want ErrPermissionDenied", err)
+			}
+			if !strings.HasSuffix(err.Error(), tt.reason) {
+				t.Errorf("err = %v, want reason %q", err, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRouterPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		req     PostReq
+		run     string
+		denied  string
+		replies int
+	}{
+		{name: "inherited from parent, allowed", text: "deploy rollback order", req: PostReq{IsAdmin: true, ConversationId: "ops"}, run: "deploy rollback", replies: 0},
+		{name: "inherited from parent, denied", text: "deploy rollback order", req: PostReq{ConversationId: "ops"}, denied: "deploy rollback", replies: 1},
+		{name: "child policy denied", text: "deploy rollback order", req: PostReq{IsAdmin: true, ConversationId: "dev"}, denied: "deploy rollback", replies: 1},
+		{name: "child policy not applied to parent", text: "deploy order", req: PostReq{IsAdmin: true, ConversationId: "dev"}, run: "deploy"},
+		{name: "router policy", text: "status", req: PostReq{SenderCorpId: "other"}, denied: "status", replies: 1},
+		{name: "help bypass", text: "help deploy rollback", req: PostReq{SenderCorpId: "other"}, replies: 1},
+		{name: "-h bypass", text: "deploy rollback -h", req: PostReq{SenderCorpId: "other"}, replies: 1},
+		{name: "--help bypass", text: "deploy --help", req: PostReq{SenderCorpId: "other"}, replies: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var run string
+			var denied []*DeniedRecord
+			var errs []error
+			var replies []*Message
+			handler := func(ctx *CommandContext) error {
+				run = ctx.Command.Path()
+				return nil
+			}
+
+			r := NewRouter("Bot")
+			r.Policy(AnyOf(AllowCorp("corp"), RequireAdmin()))
+			deploy := r.Command("deploy", "部署服务", handler).Policy(RequireAdmin())
+			deploy.Command("rollback", "回滚", handler).Policy(AllowConversations("ops"))
+			r.Command("status", "查看状态", handler)
+			r.ReplyFunc = func(req *PostReq, msg *Message) error {
+				replies = append(replies, msg)
+				return nil
+			}
+			r.OnDenied = func(rec *DeniedRecord) { denied = append(denied, rec) }
+			r.OnError = func(req *PostReq, err error) { errs = append(errs, err) }
+
+			req := tt.req
+			req.Text.Content = "@Bot " + tt.text
+			if err := r.Dispatch(&req); err != nil {
+				t.Fatalf("Dispatch returned %v", err)
+			}
+			if len(errs) != 0 {
+				t.Errorf("OnError called with %v", errs)
+			}
+			if run != tt.run {
+				t.Errorf("ran %q, want %q", run, tt.run)
+			}
+			if tt.denied == "" {
+				if len(denied) != 0 {
+					t.Errorf("denied %+v, want none", denied[0])
+				}
+			} else if len(denied) != 1 || denied[0].Command != tt.denied {
+				t.Errorf("denied %d records, want 1 for %q", len(denied), tt.denied)
+			}
+			if len(replies) != tt.replies {
+				t.Errorf("replied %d messages, want %d", len(replies), tt.replies)
+			}
+		})
+	}
+}
+
+func TestDeniedReplyError(t *testing.T) {
+	replyErr := errors.New("reply failed")
+	r := NewRouter("Bot")
+	r.Command("deploy", "部署服务", func(ctx *CommandContext) error { return nil }).Policy(RequireAdmin())
+	r.ReplyFunc = func(req *PostReq, msg *Message) error { return replyErr }
+
+	var denied int
+	r.OnDenied = func(rec *DeniedRecord) { denied++ }
+	req := &PostReq{Text: Text{Content: "deploy"}}
+	err := r.Dispatch(req)
+	if !errors.Is(err, replyErr) || errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("err = %v, want only the reply error", err)
+	}
+	if denied != 1 {
+		t.Errorf("OnDenied called %d times, want 1", denied)
+	}
+
+	r.DenyReply = func(rec *DeniedRecord) *Message { return nil }
+	if err = r.Dispatch(req); err != nil {
+		t.Errorf("err = %v, want nil when DenyReply returns nil", err)
+	}
+}
+
+func TestRouterPolicyRequiresSecret(t *testing.T) {
+	const body = `{"isAdmin":true,"text":{"content":"deploy"}}`
+	signed := func(req *http.Request, secret string) {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		req.Header.Set("timestamp", ts)
+		req.Header.Set("sign", GetDingSign(ts, secret))
+	}
+	tests := []struct {
+		name       string
+		secret     string
+		policy     bool
+		sign       string
+		wantStatus int
+		wantRun    bool
+	}{
+		{name: "policy without secret", policy: true, wantStatus: http.StatusInternalServerError},
+		{name: "policy with secret, unsigned", secret: "SEC", policy: true, wantStatus: http.StatusUnauthorized},
+		{name: "policy with secret, wrong sign", secret: "SEC", policy: true, sign: "SECwrong", wantStatus: http.StatusUnauthorized},
+		{name: "policy with secret, signed", secret: "SEC", policy: true, sign: "SEC", wantStatus: http.StatusOK, wantRun: true},
+		{name: "no policy without secret", wantStatus: http.StatusOK, wantRun: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := make(chan struct{}, 1)
+			r := NewRouter("Bot")
+			r.Secret = tt.secret
+			r.ReplyFunc = func(req *PostReq, msg *Message) error { return nil }
+			cmd := r.Command("deploy", "部署服务", func(ctx *CommandContext) error {
+				ran <- struct{}{}
+				return nil
+			})
+			if tt.policy {
+				cmd.Policy(RequireAdmin())
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/robot", strings.NewReader(body))
+			if tt.sign != "" {
+				signed(req, tt.sign)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+			select {
+			case <-ran:
+				if !tt.wantRun {
+					t.Error("command ran, want rejected")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantRun {
+					t.Error("command did not run")
+				}
+			}
+		})
+	}
+}
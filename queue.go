@@ -0,0 +1,203 @@
+package ding
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// OverflowPolicy 队列满了之后的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞等待队列有空位
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest 丢弃队列中最早的消息
+	OverflowDropOldest
+	// OverflowDropNewest 丢弃新入队的消息
+	OverflowDropNewest
+)
+
+var (
+	// DefaultQueueSize 队列默认的缓冲大小
+	DefaultQueueSize = 1024
+	// DefaultQueueWorkers 队列默认的并发发送数
+	DefaultQueueWorkers = 2
+
+	// ErrQueueClosed 队列已经关闭
+	ErrQueueClosed = errors.New("ding: queue is closed")
+	// ErrQueueFull 队列满了，消息被丢弃
+	ErrQueueFull = errors.New("ding: queue is full, message dropped")
+)
+
+// QueueCallback 消息发送完成或被丢弃后的回调，err为nil表示发送成功
+type QueueCallback func(msg *Message, err error)
+
+type queueItem struct {
+	msg      *Message
+	callback QueueCallback
+}
+
+// QueueOption 创建队列的选项
+type QueueOption func(q *Queue)
+
+// WithQueueResult 每条消息的默认回调，Enqueue 时指定了回调则不会调用这个
+func WithQueueResult(cb QueueCallback) QueueOption {
+	return func(q *Queue) {
+		q.onResult = cb
+	}
+}
+
+// Queue 异步发送队列，入队后由后台的worker发送，避免在请求处理中等待钉钉的响应。
+// Queue 本身也实现了 Sender，Send 即为不带回调的 Enqueue
+type Queue struct {
+	sender   Sender
+	policy   OverflowPolicy
+	onResult QueueCallback
+	ch       chan queueItem
+	wg       sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+	// 关闭时close，唤醒阻塞在入队上的调用
+	quit chan struct{}
+	// 正在入队的调用，全部返回后才能close(ch)
+	enqueuing sync.WaitGroup
+}
+
+// NewQueue 创建异步发送队列并启动worker。size为缓冲大小，workers为并发发送数，<=0时使用默认值
+func NewQueue(sender Sender, size, workers int, policy OverflowPolicy, opts ...QueueOption) *Queue {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	if workers <= 0 {
+		workers = DefaultQueueWorkers
+	}
+	q := &Queue{
+		sender: sender,
+		policy: policy,
+		ch:     make(chan queueItem, size),
+		quit:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for it := range q.ch {
+		q.done(it, q.sender.Send(it.msg))
+	}
+}
+
+func (q *Queue) done(it queueItem, err error) {
+	cb := it.callback
+	if cb == nil {
+		cb = q.onResult
+	}
+	if cb != nil {
+		cb(it.msg, err)
+		return
+	}
+	if err != nil {
+		log.Printf("send ding message from queue to %s failed: %s\n", TargetName(q.sender), err)
+	}
+}
+
+// Enqueue 消息入队，callback为发送完成或被丢弃后的回调，可以为nil。
+// 队列满时按 OverflowPolicy 处理：OverflowBlock 会阻塞到有空位或者队列关闭，OverflowDropNewest 返回 ErrQueueFull，
+// OverflowDropOldest 丢弃最早的消息，被丢弃消息的回调会收到 ErrQueueFull
+func (q *Queue) Enqueue(msg *Message, callback QueueCallback) error {
+	// 只在检查是否关闭时持有锁，阻塞入队时不持有，Shutdown 不会被阻塞
+	q.mu.RLock()
+	if q.closed {
+		q.mu.RUnlock()
+		return ErrQueueClosed
+	}
+	q.enqueuing.Add(1)
+	q.mu.RUnlock()
+	defer q.enqueuing.Done()
+
+	it := queueItem{msg: msg, callback: callback}
+	switch q.policy {
+	case OverflowDropNewest:
+		select {
+		case q.ch <- it:
+			return nil
+		default:
+			q.done(it, ErrQueueFull)
+			return ErrQueueFull
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.ch <- it:
+				return nil
+			case <-q.quit:
+				return ErrQueueClosed
+			default:
+			}
+			select {
+			case old := <-q.ch:
+				q.done(old, ErrQueueFull)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- it:
+			return nil
+		case <-q.quit:
+			return ErrQueueClosed
+		}
+	}
+}
+
+// Send 不带回调的入队，实现 Sender
+func (q *Queue) Send(msg *Message) error {
+	return q.Enqueue(msg, nil)
+}
+
+// Len 队列中等待发送的消息数
+func (q *Queue) Len() int {
+	return len(q.ch)
+}
+
+func (q *Queue) String() string {
+	return "queue:" + TargetName(q.sender)
+}
+
+// Shutdown 关闭队列，不再接收新消息，阻塞在入队上的调用返回 ErrQueueClosed，并等待队列中的消息全部发送完成。
+// 不管ctx是否已经结束都会先关闭队列；ctx结束时不再等待，返回ctx.Err()，剩下的消息仍会在后台继续发送
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.quit)
+		go func() {
+			// 等正在入队的调用都返回后再关闭ch，worker发送完剩下的消息后退出
+			q.enqueuing.Wait()
+			close(q.ch)
+		}()
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,199 @@
+package ding_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// gatedSender 打开gate之前阻塞发送，用于让消息留在队列中
+type gatedSender struct {
+	next ding.Sender
+	gate chan struct{}
+}
+
+func (s *gatedSender) Send(msg *ding.Message) error {
+	<-s.gate
+	return s.next.Send(msg)
+}
+
+func newQueueTarget(t *testing.T) (*dingtest.Server, *gatedSender) {
+	t.Helper()
+	srv := dingtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddRobot("token", "")
+	c, err := srv.NewWhClient("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, &gatedSender{next: c, gate: make(chan struct{})}
+}
+
+func TestQueueShutdownDrains(t *testing.T) {
+	srv, s := newQueueTarget(t)
+	var sent atomic.Int32
+	q := ding.NewQueue(s, 10, 2, ding.OverflowBlock, ding.WithQueueResult(func(msg *ding.Message, err error) {
+		if err == nil {
+			sent.Add(1)
+		}
+	}))
+	for i := 0; i < 10; i++ {
+		if err := q.Send(ding.NewTextMessage("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(s.gate)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Messages()); n != 10 || sent.Load() != 10 {
+		t.Errorf("delivered %d messages, %d results, want 10", n, sent.Load())
+	}
+	if err := q.Send(ding.NewTextMessage("late")); !errors.Is(err, ding.ErrQueueClosed) {
+		t.Errorf("Send after Shutdown = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestQueueShutdownWithDoneContext(t *testing.T) {
+	srv, s := newQueueTarget(t)
+	q := ding.NewQueue(s, 10, 1, ding.OverflowBlock)
+	if err := q.Send(ding.NewTextMessage("queued")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Shutdown = %v, want context.Canceled", err)
+	}
+	if err := q.Send(ding.NewTextMessage("late")); !errors.Is(err, ding.ErrQueueClosed) {
+		t.Errorf("Send after Shutdown = %v, want ErrQueueClosed", err)
+	}
+
+	// 已经入队的消息在后台继续发送，worker发送完后退出
+	close(s.gate)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(srv.Messages()); n != 1 {
+		t.Errorf("delivered %d messages, want 1", n)
+	}
+}
+
+func TestQueueShutdownUnblocksEnqueue(t *testing.T) {
+	_, s := newQueueTarget(t)
+	q := ding.NewQueue(s, 1, 1, ding.OverflowBlock)
+	// worker取走第一条后阻塞在gate上，第二条占满缓冲，第三条阻塞入队
+	_ = q.Send(ding.NewTextMessage("1"))
+	time.Sleep(20 * time.Millisecond)
+	_ = q.Send(ding.NewTextMessage("2"))
+
+	blocked := make(chan error, 1)
+	go func() { blocked <- q.Send(ding.NewTextMessage("3")) }()
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want context.DeadlineExceeded while the sender is blocked", err)
+	}
+	select {
+	case err := <-blocked:
+		if !errors.Is(err, ding.ErrQueueClosed) {
+			t.Errorf("blocked Send = %v, want ErrQueueClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blocked Send did not return after Shutdown")
+	}
+	close(s.gate)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      ding.OverflowPolicy
+		wantErr     error
+		wantDropped []string
+		wantSent    []string
+	}{
+		{name: "drop newest", policy: ding.OverflowDropNewest, wantErr: ding.ErrQueueFull, wantDropped: []string{"3"}, wantSent: []string{"1", "2"}},
+		{name: "drop oldest", policy: ding.OverflowDropOldest, wantDropped: []string{"2"}, wantSent: []string{"1", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, s := newQueueTarget(t)
+			var mu sync.Mutex
+			var dropped []string
+			q := ding.NewQueue(s, 1, 1, tt.policy, ding.WithQueueResult(func(msg *ding.Message, err error) {
+				if errors.Is(err, ding.ErrQueueFull) {
+					mu.Lock()
+					dropped = append(dropped, msg.Text)
+					mu.Unlock()
+				}
+			}))
+			_ = q.Send(ding.NewTextMessage("1"))
+			time.Sleep(20 * time.Millisecond)
+			_ = q.Send(ding.NewTextMessage("2"))
+			if err := q.Send(ding.NewTextMessage("3")); !errors.Is(err, tt.wantErr) && err != tt.wantErr {
+				t.Errorf("third Send = %v, want %v", err, tt.wantErr)
+			}
+			close(s.gate)
+			if err := q.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(dropped) != len(tt.wantDropped) || dropped[0] != tt.wantDropped[0] {
+				t.Errorf("dropped %q, want %q", dropped, tt.wantDropped)
+			}
+			msgs := srv.Messages()
+			if len(msgs) != len(tt.wantSent) {
+				t.Fatalf("delivered %d messages, want %d", len(msgs), len(tt.wantSent))
+			}
+			for i, want := range tt.wantSent {
+				if msgs[i].Text != want {
+					t.Errorf("message %d = %q, want %q", i, msgs[i].Text, want)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueConcurrentShutdown(t *testing.T) {
+	srv, s := newQueueTarget(t)
+	close(s.gate)
+	var results atomic.Int32
+	q := ding.NewQueue(s, 4, 4, ding.OverflowBlock, ding.WithQueueResult(func(msg *ding.Message, err error) {
+		results.Add(1)
+	}))
+
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if q.Send(ding.NewTextMessage("hello")) == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if got := int32(len(srv.Messages())); got != accepted.Load() || results.Load() != accepted.Load() {
+		t.Errorf("accepted %d, delivered %d, results %d", accepted.Load(), got, results.Load())
+	}
+}
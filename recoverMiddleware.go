@@ -0,0 +1,149 @@
+package ding
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultRedactHeaders 默认需要脱敏的请求头
+	DefaultRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-Api-Key", "X-Acs-Dingtalk-Access-Token"}
+	// DefaultPanicTitle panic报告默认的标题前缀
+	DefaultPanicTitle = "服务panic"
+	// MaxPanicStackSize panic报告中堆栈的最大字节数，钉钉消息有长度限制
+	MaxPanicStackSize = 6000
+
+	stackAddrRe = regexp.MustCompile(`\s\+0x[0-9a-f]+$`)
+	stackArgsRe = regexp.MustCompile(`\([^()]*\)$`)
+	stackGidRe  = regexp.MustCompile(` in goroutine \d+$`)
+)
+
+// RecoverOptions panic恢复中间件的配置
+type RecoverOptions struct {
+	// 消息标题前缀，为空时使用 DefaultPanicTitle
+	Title string
+	// 需要脱敏的请求头，为nil时使用 DefaultRedactHeaders
+	RedactHeaders []string
+	// 相同堆栈的panic在这个时间内只报告一次，<=0表示不去重
+	DedupWindow time.Duration
+	// 为true时报告后重新panic，交给外层处理；否则返回500
+	RePanic bool
+}
+
+// Recover 返回 net/http 中间件，handler中panic时恢复，并把堆栈、请求方法、路径和请求头（脱敏后）
+// 以markdown消息发送到sender，sender可以是 WhClient 或 GroupClient.Sender(openConversationId)
+func Recover(sender Sender, opts *RecoverOptions) func(http.Handler) http.Handler {
+	o := RecoverOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Title == "" {
+		o.Title = DefaultPanicTitle
+	}
+	if o.RedactHeaders == nil {
+		o.RedactHeaders = DefaultRedactHeaders
+	}
+	redact := make(map[string]bool, len(o.RedactHeaders))
+	for _, h := range o.RedactHeaders {
+		redact[http.CanonicalHeaderKey(h)] = true
+	}
+	var dedup *Deduper
+	if o.DedupWindow > 0 {
+		dedup = NewDeduper(sender, o.DedupWindow, nil)
+		dedup.Summary = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				stack := debug.Stack()
+				msg := panicReport(o.Title, v, r, redact, stack)
+				go func() {
+					var err error
+					if dedup != nil {
+						err = dedup.SendWithKey(StackSignature(stack), msg)
+					} else {
+						err = sender.Send(msg)
+					}
+					if err != nil {
+						log.Printf("send panic report to %s failed: %s\n", TargetName(sender), err)
+					}
+				}()
+				if o.RePanic {
+					panic(v)
+				}
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// panicReport 生成panic报告的markdown消息
+func panicReport(title string, v any, r *http.Request, redact map[string]bool, stack []byte) *Message {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s: %v\n\n", title, v)
+	fmt.Fprintf(&b, "- **time**: %s\n", time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&b, "- **method**: %s\n", r.Method)
+	fmt.Fprintf(&b, "- **path**: %s\n", r.URL.Path)
+	if r.Host != "" {
+		fmt.Fprintf(&b, "- **host**: %s\n", r.Host)
+	}
+	fmt.Fprintf(&b, "- **remote**: %s\n", r.RemoteAddr)
+
+	names := make([]string, 0, len(r.Header))
+	for k := range r.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		b.WriteString("- **headers**:\n")
+	}
+	for _, k := range names {
+		value := strings.Join(r.Header[k], ", ")
+		if redact[k] {
+			value = "[REDACTED]"
+		}
+		fmt.Fprintf(&b, "  - %s: %s\n", k, value)
+	}
+
+	s := string(stack)
+	if len(s) > MaxPanicStackSize {
+		s = s[:MaxPanicStackSize] + "\n..."
+	}
+	fmt.Fprintf(&b, "\n```\n%s\n```", s)
+	return NewMarkdownMessage(fmt.Sprintf("%s: %v", title, v), b.String())
+}
+
+// StackSignature 计算堆栈的签名，去掉goroutine编号、参数和地址后只保留函数和代码位置，
+// 同一个位置的panic签名相同
+func StackSignature(stack []byte) string {
+	lines := strings.Split(string(stack), "\n")
+	var keep []string
+	for _, line := range lines {
+		if line == "" || strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		line = stackAddrRe.ReplaceAllString(line, "")
+		line = stackArgsRe.ReplaceAllString(line, "()")
+		line = stackGidRe.ReplaceAllString(line, "")
+		keep = append(keep, strings.TrimSpace(line))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(keep, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
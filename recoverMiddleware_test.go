@@ -0,0 +1,167 @@
+package ding_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// waitMessages 等待假服务收到n条消息，panic报告是在后台发送的
+func waitMessages(t *testing.T, srv *dingtest.Server, n int) []*dingtest.Received {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		msgs := srv.Messages()
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func panicHandler(v any) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(v)
+	})
+}
+
+func TestRecoverReport(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("ops", "")
+	c, err := srv.NewWhClient("ops", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := ding.Recover(c, &ding.RecoverOptions{Title: "api panic"})(panicHandler("nil map"))
+
+	r := httptest.NewRequest(http.MethodPost, "/orders?id=1", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Cookie", "session=secret-cookie")
+	r.Header.Set("X-Request-Id", "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+
+	msgs := waitMessages(t, srv, 1)
+	if len(msgs) != 1 {
+		t.Fatalf("delivered %d reports, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.MsgType != ding.WhMsgTypeMarkdown || got.Title != "api panic: nil map" {
+		t.Errorf("report = %s %q", got.MsgType, got.Title)
+	}
+	for _, s := range []string{"POST", "/orders", "X-Request-Id: req-1", "Authorization: [REDACTED]", "Cookie: [REDACTED]", "TestRecoverReport"} {
+		if !strings.Contains(got.Text, s) {
+			t.Errorf("report does not contain %q", s)
+		}
+	}
+	for _, s := range []string{"secret-token", "secret-cookie"} {
+		if strings.Contains(got.Text, s) {
+			t.Errorf("report leaks %q", s)
+		}
+	}
+}
+
+func TestRecoverPassThrough(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("ops", "")
+	c, _ := srv.NewWhClient("ops", "")
+	h := ding.Recover(c, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", w.Code)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("delivered %d reports without a panic", n)
+	}
+}
+
+func TestRecoverRePanic(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("ops", "")
+	c, _ := srv.NewWhClient("ops", "")
+
+	tests := []struct {
+		name       string
+		v          any
+		opts       *ding.RecoverOptions
+		wantReport bool
+	}{
+		{name: "re-panic", v: "boom", opts: &ding.RecoverOptions{RePanic: true}, wantReport: true},
+		// http.ErrAbortHandler 是主动中断请求，不报告
+		{name: "abort handler", v: http.ErrAbortHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			h := ding.Recover(c, tt.opts)(panicHandler(tt.v))
+			func() {
+				defer func() {
+					if v := recover(); v != tt.v {
+						t.Errorf("recovered %v, want %v", v, tt.v)
+					}
+				}()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}()
+			want := 0
+			if tt.wantReport {
+				want = 1
+			}
+			msgs := waitMessages(t, srv, want)
+			time.Sleep(50 * time.Millisecond)
+			if len(msgs) != want {
+				t.Errorf("delivered %d reports, want %d", len(msgs), want)
+			}
+		})
+	}
+}
+
+func TestRecoverDedup(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("ops", "")
+	c, _ := srv.NewWhClient("ops", "")
+	mw := ding.Recover(c, &ding.RecoverOptions{DedupWindow: time.Minute})
+	same := mw(panicHandler("same place"))
+	other := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["x"]++
+	}))
+
+	// 同一个位置的panic只报告一次，不同位置的单独报告
+	for i := 0; i < 5; i++ {
+		same.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/same", nil))
+	}
+	other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/other", nil))
+	waitMessages(t, srv, 2)
+	time.Sleep(50 * time.Millisecond)
+	if n := len(srv.Messages()); n != 2 {
+		t.Fatalf("delivered %d reports, want 2", n)
+	}
+}
+
+func TestStackSignature(t *testing.T) {
+	a := []byte("goroutine 7 [running]:\nmain.handler(0xc000010000, 0x1)\n\t/app/main.go:12 +0x1d\ncreated by main.serve in goroutine 1\n\t/app/main.go:30 +0x45\n")
+	b := []byte("goroutine 42 [running]:\nmain.handler(0xc000020000, 0x2)\n\t/app/main.go:12 +0x2f\ncreated by main.serve in goroutine 3\n\t/app/main.go:30 +0x45\n")
+	c := []byte("goroutine 7 [running]:\nmain.handler(0xc000010000, 0x1)\n\t/app/main.go:13 +0x1d\n")
+	if ding.StackSignature(a) != ding.StackSignature(b) {
+		t.Error("stacks from the same place have different signatures")
+	}
+	if ding.StackSignature(a) == ding.StackSignature(c) {
+		t.Error("stacks from different lines have the same signature")
+	}
+}
@@ -0,0 +1,143 @@
+package ding_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+// writeRegistry 把配置写到path
+func writeRegistry(t *testing.T, path string, targets map[string]ding.TargetConfig) {
+	t.Helper()
+	b, err := json.Marshal(ding.RegistryConfig{Targets: targets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryOptions(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("ops-token", "ops-secret")
+	srv.AddApp("app-key", "app-secret")
+
+	targets := map[string]ding.TargetConfig{
+		"ops":      {Type: ding.TargetTypeWebhook, AccessToken: "ops-token", Secret: "ops-secret", Endpoint: srv.WebhookUrl()},
+		"war-room": {Type: ding.TargetTypeGroup, RobotCode: "robot", AppKey: "app-key", AppSecret: "app-secret", OpenConversationId: "cid1", Endpoint: srv.GroupUrl()},
+		"oncall":   {Type: ding.TargetTypeOtO, RobotCode: "robot", AppKey: "app-key", AppSecret: "app-secret", UserIds: []string{"u1"}, Endpoint: srv.OtOUrl()},
+	}
+	d := ding.NewDeduper(nil, time.Minute, nil)
+	r, err := ding.NewRegistryFromConfig(&ding.RegistryConfig{Targets: targets}, append(srv.Options(), ding.WithDeduper(d))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"ops", "war-room", "oncall"} {
+		s, err := r.Sender(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 第二次被 WithDeduper 抑制
+		for i := 0; i < 2; i++ {
+			if err = s.Send(ding.NewTextMessage("disk full")); err != nil {
+				t.Fatalf("send to %s: %v", name, err)
+			}
+		}
+	}
+	tests := []struct {
+		name     string
+		endpoint string
+	}{
+		{name: "ops", endpoint: dingtest.EndpointWebhook},
+		{name: "war-room", endpoint: dingtest.EndpointGroup},
+		{name: "oncall", endpoint: dingtest.EndpointOtO},
+	}
+	for _, tt := range tests {
+		if n := len(srv.Messages(tt.endpoint)); n != 1 {
+			t.Errorf("%s: delivered %d messages, want 1", tt.name, n)
+		}
+	}
+}
+
+func TestRegistryInvalidTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		tc   ding.TargetConfig
+	}{
+		{name: "no token", tc: ding.TargetConfig{Type: ding.TargetTypeWebhook}},
+		{name: "bad dsn", tc: ding.TargetConfig{Type: ding.TargetTypeWebhook, DSN: "http://token@robot"}},
+		{name: "no robotCode", tc: ding.TargetConfig{Type: ding.TargetTypeGroup, AppKey: "k", AppSecret: "s", OpenConversationId: "cid"}},
+		{name: "no userIds", tc: ding.TargetConfig{Type: ding.TargetTypeOtO, RobotCode: "r", AppKey: "k", AppSecret: "s"}},
+		{name: "unknown type", tc: ding.TargetConfig{Type: "mail"}},
+		{name: "missing env", tc: ding.TargetConfig{Type: ding.TargetTypeWebhook, AccessToken: "env:DING_REGISTRY_TEST_UNSET"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ding.NewRegistryFromConfig(&ding.RegistryConfig{Targets: map[string]ding.TargetConfig{"x": tt.tc}})
+			if err == nil {
+				t.Fatal("NewRegistryFromConfig returned nil error")
+			}
+		})
+	}
+}
+
+func TestRegistryWatch(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("old-token", "")
+	srv.AddRobot("new-token", "")
+
+	path := filepath.Join(t.TempDir(), "ding.json")
+	writeRegistry(t, path, map[string]ding.TargetConfig{
+		"ops": {Type: ding.TargetTypeWebhook, AccessToken: "old-token", Endpoint: srv.WebhookUrl()},
+	})
+	r, err := ding.NewRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// interval<=0 时使用默认间隔，不会panic
+	r.Watch(0)()
+	r.Watch(-time.Second)()
+
+	stop := r.Watch(10 * time.Millisecond)
+	defer stop()
+	writeRegistry(t, path, map[string]ding.TargetConfig{
+		"ops":     {Type: ding.TargetTypeWebhook, AccessToken: "new-token", Endpoint: srv.WebhookUrl()},
+		"oncall2": {Type: ding.TargetTypeWebhook, AccessToken: "new-token", Endpoint: srv.WebhookUrl()},
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.Names()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("registry was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c, err := r.Webhook("ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AccessToken != "new-token" {
+		t.Errorf("AccessToken = %q, want new-token", c.AccessToken)
+	}
+
+	// 配置错误时保留原来的目标
+	stop()
+	if err = os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Reload(); err == nil {
+		t.Fatal("Reload accepted invalid json")
+	}
+	if _, err = r.Get("oncall2"); err != nil {
+		t.Errorf("Get after failed reload: %v", err)
+	}
+}
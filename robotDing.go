@@ -0,0 +1,86 @@
+// 机器人DING消息，用于紧急升级：应用内、短信或电话提醒，有人响应后可以撤回
+// 参考： https://open.dingtalk.com/document/orgapp/robot-sends-ding-messages
+// https://open.dingtalk.com/document/orgapp/robot-withdraws-ding-messages
+
+package ding
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DING消息接口的路径，地址为客户端的新版接口地址加上路径，见 WithBaseUrl
+const (
+	// RobotDingSendPath 机器人发送DING消息
+	RobotDingSendPath = "/v1.0/robot/ding/send"
+	// RobotDingRecallPath 机器人撤回DING消息
+	RobotDingRecallPath = "/v1.0/robot/ding/recall"
+)
+
+// DingRemindType DING消息的提醒方式
+type DingRemindType int
+
+const (
+	// DingRemindApp 应用内提醒
+	DingRemindApp DingRemindType = 1
+	// DingRemindSMS 短信提醒
+	DingRemindSMS DingRemindType = 2
+	// DingRemindCall 电话提醒
+	DingRemindCall DingRemindType = 3
+)
+
+func (t DingRemindType) String() string {
+	switch t {
+	case DingRemindApp:
+		return "app"
+	case DingRemindSMS:
+		return "sms"
+	case DingRemindCall:
+		return "call"
+	}
+	return fmt.Sprintf("DingRemindType(%d)", int(t))
+}
+
+// Ding 已经发送的DING消息
+type Ding struct {
+	// DING消息的id，用于撤回
+	OpenDingId string
+	client     *IClient
+}
+
+// Recall 撤回这条DING消息，如有人已经响应
+func (d *Ding) Recall() error {
+	return d.client.RecallDing(d.OpenDingId)
+}
+
+// SendDing 以机器人的身份给userIds发送DING消息，remindType为提醒方式，短信和电话提醒会产生费用
+func (c *IClient) SendDing(remindType DingRemindType, content string, userIds ...string) (*Ding, error) {
+	if len(userIds) == 0 {
+		return nil, fmt.Errorf("%w: DING has no recipients", ErrInvalidRecipients)
+	}
+	if remindType < DingRemindApp || remindType > DingRemindCall {
+		return nil, fmt.Errorf("%w: unknown remind type %d", ErrInvalidParam, remindType)
+	}
+	req := map[string]any{
+		"robotCode":          c.RobotCode,
+		"remindType":         int(remindType),
+		"receiverUserIdList": userIds,
+		"content":            content,
+	}
+	var resp struct {
+		OpenDingId string `json:"openDingId"`
+	}
+	if err := c.callApi(http.MethodPost, c.apiUrl(RobotDingSendPath), req, &resp); err != nil {
+		return nil, err
+	}
+	return &Ding{OpenDingId: resp.OpenDingId, client: c}, nil
+}
+
+// RecallDing 撤回机器人发送的DING消息
+func (c *IClient) RecallDing(openDingId string) error {
+	req := map[string]string{
+		"robotCode":  c.RobotCode,
+		"openDingId": openDingId,
+	}
+	return c.callApi(http.MethodPost, c.apiUrl(RobotDingRecallPath), req, nil)
+}
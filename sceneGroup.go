@@ -0,0 +1,161 @@
+// 场景群，通过群模板创建群、管理群成员、修改群名称和群主，用于按事件创建作战群等场景。
+// 群模板中添加了机器人时，可以用 GroupClient 向返回的 openConversationId 发送消息
+// 参考： https://open.dingtalk.com/document/group/create-a-scene-group-session
+// https://open.dingtalk.com/document/group/add-group-members
+// https://open.dingtalk.com/document/group/update-group-session
+
+package ding
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 场景群接口的路径，地址为客户端的旧版接口地址加上路径，见 WithBaseUrl
+const (
+	// SceneGroupCreatePath 创建场景群
+	SceneGroupCreatePath = "/topapi/im/chat/scenegroup/create"
+	// SceneGroupUpdatePath 修改场景群
+	SceneGroupUpdatePath = "/topapi/im/chat/scenegroup/update"
+	// SceneGroupGetPath 查询场景群
+	SceneGroupGetPath = "/topapi/im/chat/scenegroup/get"
+	// SceneGroupMemberAddPath 添加场景群成员
+	SceneGroupMemberAddPath = "/topapi/im/chat/scenegroup/member/add"
+	// SceneGroupMemberDeletePath 删除场景群成员
+	SceneGroupMemberDeletePath = "/topapi/im/chat/scenegroup/member/delete"
+)
+
+// SceneGroupClient 场景群客户端
+type SceneGroupClient struct {
+	*IClient
+}
+
+// NewSceneGroupClient 创建场景群客户端，应用需要有场景群的权限
+func NewSceneGroupClient(appKey, appSecret string, opts ...Option) (*SceneGroupClient, error) {
+	c, err := newIClientWithOptions("", "", appKey, appSecret, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &SceneGroupClient{IClient: c}, nil
+}
+
+// SceneGroupCreateReq 创建场景群的参数
+type SceneGroupCreateReq struct {
+	// 群名称，必填
+	Title string `json:"title"`
+	// 群模板id，必填，在开发者后台创建
+	TemplateId string `json:"template_id"`
+	// 群主的userId，必填
+	OwnerUserId string `json:"owner_user_id"`
+	// 群成员的userId，逗号分隔，用 SceneGroupCreateReq.WithUsers 设置
+	UserIds string `json:"user_ids,omitempty"`
+	// 群管理员的userId，逗号分隔，用 SceneGroupCreateReq.WithSubAdmins 设置
+	SubAdminIds string `json:"subadmin_ids,omitempty"`
+	// 建群去重的业务id，同一个uuid只会创建一个群，如事件id
+	Uuid string `json:"uuid,omitempty"`
+	// 群头像的mediaId
+	Icon string `json:"icon,omitempty"`
+	// 新成员是否可以查看100条历史消息：1 可以，0 不可以
+	ShowHistoryType int `json:"show_history_type,omitempty"`
+	// @all 权限：0 所有人，1 仅群主
+	MentionAllAuthority int `json:"mention_all_authority,omitempty"`
+}
+
+// NewSceneGroupCreateReq 创建场景群的参数，title为群名称，templateId为群模板id，ownerUserId为群主
+func NewSceneGroupCreateReq(title, templateId, ownerUserId string) *SceneGroupCreateReq {
+	return &SceneGroupCreateReq{Title: title, TemplateId: templateId, OwnerUserId: ownerUserId}
+}
+
+// WithUsers 设置群成员
+func (r *SceneGroupCreateReq) WithUsers(userIds ...string) *SceneGroupCreateReq {
+	r.UserIds = strings.Join(userIds, ",")
+	return r
+}
+
+// WithSubAdmins 设置群管理员
+func (r *SceneGroupCreateReq) WithSubAdmins(userIds ...string) *SceneGroupCreateReq {
+	r.SubAdminIds = strings.Join(userIds, ",")
+	return r
+}
+
+// WithUuid 设置建群去重的业务id，重复创建时返回已经创建的群
+func (r *SceneGroupCreateReq) WithUuid(uuid string) *SceneGroupCreateReq {
+	r.Uuid = uuid
+	return r
+}
+
+// SceneGroup 场景群
+type SceneGroup struct {
+	// 开放的群id，用于 GroupClient 发送消息和管理群
+	OpenConversationId string `json:"open_conversation_id"`
+	// 群id
+	ChatId string `json:"chat_id"`
+	// 群名称，只有 Get 返回
+	Title string `json:"title"`
+	// 群主的userId，只有 Get 返回
+	OwnerUserId string `json:"owner_user_id"`
+	// 群模板id，只有 Get 返回
+	TemplateId string `json:"template_id"`
+}
+
+// Sender 返回用群聊客户端发送到这个群的Sender，群模板中需要添加这个机器人
+func (g *SceneGroup) Sender(c *GroupClient) *GroupSender {
+	return c.Sender(g.OpenConversationId)
+}
+
+// Create 通过群模板创建场景群
+func (c *SceneGroupClient) Create(req *SceneGroupCreateReq) (*SceneGroup, error) {
+	if req.Title == "" || req.TemplateId == "" || req.OwnerUserId == "" {
+		return nil, fmt.Errorf("%w: title, templateId and ownerUserId are required", ErrInvalidParam)
+	}
+	var g SceneGroup
+	if err := c.callOapi(c.oapiUrl(SceneGroupCreatePath), req, &g); err != nil {
+		return nil, err
+	}
+	if g.Title == "" {
+		g.Title, g.OwnerUserId, g.TemplateId = req.Title, req.OwnerUserId, req.TemplateId
+	}
+	return &g, nil
+}
+
+// Get 查询场景群的信息
+func (c *SceneGroupClient) Get(openConversationId string) (*SceneGroup, error) {
+	var g SceneGroup
+	if err := c.callOapi(c.oapiUrl(SceneGroupGetPath), map[string]string{"open_conversation_id": openConversationId}, &g); err != nil {
+		return nil, err
+	}
+	g.OpenConversationId = openConversationId
+	return &g, nil
+}
+
+// AddMembers 添加群成员
+func (c *SceneGroupClient) AddMembers(openConversationId string, userIds ...string) error {
+	return c.callOapi(c.oapiUrl(SceneGroupMemberAddPath), map[string]string{
+		"open_conversation_id": openConversationId,
+		"user_ids":             strings.Join(userIds, ","),
+	}, nil)
+}
+
+// RemoveMembers 删除群成员
+func (c *SceneGroupClient) RemoveMembers(openConversationId string, userIds ...string) error {
+	return c.callOapi(c.oapiUrl(SceneGroupMemberDeletePath), map[string]string{
+		"open_conversation_id": openConversationId,
+		"user_ids":             strings.Join(userIds, ","),
+	}, nil)
+}
+
+// UpdateTitle 修改群名称
+func (c *SceneGroupClient) UpdateTitle(openConversationId, title string) error {
+	return c.callOapi(c.oapiUrl(SceneGroupUpdatePath), map[string]string{
+		"open_conversation_id": openConversationId,
+		"title":                title,
+	}, nil)
+}
+
+// UpdateOwner 转让群主，新群主需要是群成员
+func (c *SceneGroupClient) UpdateOwner(openConversationId, ownerUserId string) error {
+	return c.callOapi(c.oapiUrl(SceneGroupUpdatePath), map[string]string{
+		"open_conversation_id": openConversationId,
+		"owner_user_id":        ownerUserId,
+	}, nil)
+}
@@ -0,0 +1,409 @@
+package ding
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// MsgTypeImage 图片消息，只有接口方式支持
+	MsgTypeImage = "image"
+
+	// ErrUnsupportedMsgType 发送方式不支持这种消息类型，如接口方式不支持feedCard
+	ErrUnsupportedMsgType = errors.New("ding: unsupported message type")
+)
+
+// Sender 发送通用消息，WhClient、GroupClient.Sender(群)、OtOClient.Sender(用户) 都实现了Sender，
+// 广播、队列、去重等功能都基于Sender
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// SenderFunc 函数形式的Sender
+type SenderFunc func(msg *Message) error
+
+// Send 调用函数本身
+func (f SenderFunc) Send(msg *Message) error {
+	return f(msg)
+}
+
+// TargetName 获取Sender的名字，实现了 fmt.Stringer 时使用 String()，用于日志和结果汇总
+func TargetName(s Sender) string {
+	if st, ok := s.(fmt.Stringer); ok {
+		return st.String()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+// TargetKey 获取Sender的唯一标识，用于按发送目标去重等需要区分目标的场景。
+// 实现了 TargetKey() string 时使用它，否则使用 TargetName。
+// 客户端的名字只用于显示，会截断或者省略access_token等，不能用来区分目标
+func TargetKey(s Sender) string {
+	if k, ok := s.(interface{ TargetKey() string }); ok {
+		return k.TargetKey()
+	}
+	return TargetName(s)
+}
+
+// hashTargetKey 用完整的地址、token等计算目标的唯一标识，不直接保存token
+func hashTargetKey(kind string, parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return kind + ":" + hex.EncodeToString(sum[:])
+}
+
+// Message 通用消息，webhook方式和接口方式发送时会转换成各自的格式
+type Message struct {
+	// 消息类型：WhMsgTypeText、WhMsgTypeMarkdown、WhMsgTypeLink、WhMsgTypeActionCard、WhMsgTypeFeedCard、MsgTypeImage
+	MsgType string `json:"msgType"`
+	// markdown、link、actionCard消息的标题
+	Title string `json:"title,omitempty"`
+	// text消息的内容，markdown、link、actionCard消息的正文
+	Text string `json:"text,omitempty"`
+	// link消息的图片URL
+	PicUrl string `json:"picUrl,omitempty"`
+	// link消息点击跳转的URL
+	MessageUrl string `json:"messageUrl,omitempty"`
+	// 整体跳转actionCard单个按钮的标题
+	SingleTitle string `json:"singleTitle,omitempty"`
+	// 整体跳转actionCard单个按钮的跳转链接
+	SingleURL string `json:"singleURL,omitempty"`
+	// 独立跳转actionCard按钮排列顺序，0竖直排列，1横向排列
+	BtnOrientation string `json:"btnOrientation,omitempty"`
+	// 独立跳转actionCard的按钮
+	Btns []*Btn `json:"btns,omitempty"`
+	// feedCard的链接
+	Links []*Link `json:"links,omitempty"`
+	// 图片消息的图片URL
+	PhotoURL string `json:"photoURL,omitempty"`
+	// @谁，只有webhook方式的text和markdown消息支持
+	At At `json:"at"`
+
+	// 为true时text和markdown消息的内容原样发送，不补 @userId 和 @手机号，
+	// 用于 NewMessageFromWhMsg 转换的消息，保证和直接发送Wh*Msg的内容一致
+	rawText bool
+	// 发送的上下文，见 WithContext
+	ctx context.Context
+}
+
+// NewTextMessage 文本消息
+func NewTextMessage(content string) *Message {
+	return &Message{MsgType: WhMsgTypeText, Text: content}
+}
+
+// NewMarkdownMessage markdown消息
+func NewMarkdownMessage(title, text string) *Message {
+	return &Message{MsgType: WhMsgTypeMarkdown, Title: title, Text: text}
+}
+
+// NewLinkMessage link链接消息
+func NewLinkMessage(title, text, picUrl, messageUrl string) *Message {
+	return &Message{MsgType: WhMsgTypeLink, Title: title, Text: text, PicUrl: picUrl, MessageUrl: messageUrl}
+}
+
+// NewActionCardMessage 整体跳转actionCard消息
+func NewActionCardMessage(title, text, singleTitle, singleURL string) *Message {
+	return &Message{MsgType: WhMsgTypeActionCard, Title: title, Text: text, SingleTitle: singleTitle, SingleURL: singleURL}
+}
+
+// NewIndependentActionCardMessage 独立跳转actionCard消息
+func NewIndependentActionCardMessage(title, text, btnOrientation string, btns []*Btn) *Message {
+	return &Message{MsgType: WhMsgTypeActionCard, Title: title, Text: text, BtnOrientation: btnOrientation, Btns: btns}
+}
+
+// NewFeedCardMessage feedCard消息，只有webhook方式支持
+func NewFeedCardMessage(links []*Link) *Message {
+	return &Message{MsgType: WhMsgTypeFeedCard, Links: links}
+}
+
+// NewImageMessage 图片消息，只有接口方式支持
+func NewImageMessage(photoURL string) *Message {
+	return &Message{MsgType: MsgTypeImage, PhotoURL: photoURL}
+}
+
+// WithAtUserIds @userIds，返回消息本身方便链式调用
+func (m *Message) WithAtUserIds(userIds ...string) *Message {
+	m.At.AtUserIds = append(m.At.AtUserIds, userIds...)
+	return m
+}
+
+// WithAtMobiles @手机号，返回消息本身方便链式调用
+func (m *Message) WithAtMobiles(mobiles ...string) *Message {
+	m.At.AtMobiles = append(m.At.AtMobiles, mobiles...)
+	return m
+}
+
+// WithAtAll @所有人，返回消息本身方便链式调用
+func (m *Message) WithAtAll() *Message {
+	m.At.IsAtAll = true
+	return m
+}
+
+// WithContext 返回带有ctx的消息副本，ctx取消后重试不再等待，直接返回ctx的错误
+func (m *Message) WithContext(ctx context.Context) *Message {
+	m2 := *m
+	m2.ctx = ctx
+	return &m2
+}
+
+// Context 消息的上下文，没有设置时为 context.Background()
+func (m *Message) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+// textWithAt 钉钉要求内容中带上 @userId 或 @手机号 才有@效果，内容中没有时补上
+func (m *Message) textWithAt() string {
+	if m.rawText {
+		return m.Text
+	}
+	var a []string
+	for _, v := range append(append([]string{}, m.At.AtUserIds...), m.At.AtMobiles...) {
+		if !strings.Contains(m.Text, "@"+v) {
+			a = append(a, "@"+v)
+		}
+	}
+	if len(a) == 0 {
+		return m.Text
+	}
+	return fmt.Sprintf("%s %s", m.Text, strings.Join(a, " "))
+}
+
+// WhMsg 转换成webhook方式的消息，即message.go里定义的Wh*Msg
+func (m *Message) WhMsg() (any, error) {
+	switch m.MsgType {
+	case WhMsgTypeText:
+		msg := NewWhTextMsg(m.textWithAt())
+		msg.At = m.At
+		return msg, nil
+	case WhMsgTypeMarkdown:
+		msg := NewWhMarkdownMsg(m.Title, m.textWithAt())
+		msg.At = m.At
+		return msg, nil
+	case WhMsgTypeLink:
+		return NewWhLinkMsg(m.Text, m.Title, m.PicUrl, m.MessageUrl), nil
+	case WhMsgTypeActionCard:
+		if len(m.Btns) > 0 {
+			return NewWhIndependentActionCardMsgWithBtnOrientation(m.Title, m.Text, m.BtnOrientation, m.Btns), nil
+		}
+		return NewWhEntiretyActionCardMsg(m.Title, m.Text, m.SingleTitle, m.SingleURL), nil
+	case WhMsgTypeFeedCard:
+		return NewWhFeedCardMsg(m.Links), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnsupportedMsgType, m.MsgType)
+}
+
+// InterfaceMsg 转换成接口方式的消息模板key和参数，接口方式不支持@某人和feedCard
+func (m *Message) InterfaceMsg() (msgKey, msgParam string, err error) {
+	switch m.MsgType {
+	case WhMsgTypeText:
+		return IMsgKeyText, Text{Content: m.Text}.String(), nil
+	case WhMsgTypeMarkdown:
+		return IMsgKeyMarkdown, (&Markdown{Title: m.Title, Text: m.Text}).String(), nil
+	case WhMsgTypeLink:
+		return IMsgKeyLink, NewLink(m.Title, m.Text, m.PicUrl, m.MessageUrl).String(), nil
+	case WhMsgTypeActionCard:
+		if len(m.Btns) == 0 {
+			return IMsgKeyActionCard, NewEntiretyActionCard(m.Title, m.Text, m.SingleTitle, m.SingleURL).String(), nil
+		}
+		return m.interfaceActionCard()
+	case MsgTypeImage:
+		return IMsgKeyImage, (&Image{PhotoURL: m.PhotoURL}).String(), nil
+	}
+	return "", "", fmt.Errorf("%w: %q in interface mode", ErrUnsupportedMsgType, m.MsgType)
+}
+
+// interfaceActionCard 独立跳转actionCard，接口方式按按钮数量和排列使用不同的模板：
+// sampleActionCard2~5 为竖直排列的2~5个按钮，sampleActionCard6 为横向排列的2个按钮
+func (m *Message) interfaceActionCard() (msgKey, msgParam string, err error) {
+	n := len(m.Btns)
+	switch {
+	case n == 2 && m.BtnOrientation == "1":
+		msgKey = IMsgKeyActionCard6
+	case n == 2:
+		msgKey = IMsgKeyActionCard2
+	case n == 3:
+		msgKey = IMsgKeyActionCard3
+	case n == 4:
+		msgKey = IMsgKeyActionCard4
+	case n == 5:
+		msgKey = IMsgKeyActionCard5
+	default:
+		return "", "", fmt.Errorf("%w: actionCard with %d buttons in interface mode", ErrUnsupportedMsgType, n)
+	}
+	param := map[string]string{"title": m.Title, "text": m.Text}
+	for i, b := range m.Btns {
+		param[fmt.Sprintf("actionTitle%d", i+1)] = b.Title
+		param[fmt.Sprintf("actionURL%d", i+1)] = b.ActionURL
+	}
+	b, err := json.Marshal(param)
+	if err != nil {
+		return "", "", err
+	}
+	return msgKey, string(b), nil
+}
+
+// NewMessageFromWhMsg 把message.go里定义的Wh*Msg转换成通用消息，指针和值都可以。
+// text和markdown消息的内容原样保留，发送时不会再补 @userId 和 @手机号
+func NewMessageFromWhMsg(msg any) (*Message, error) {
+	switch m := msg.(type) {
+	case *Message:
+		return m, nil
+	case Message:
+		return &m, nil
+	case *WhTextMsg:
+		return &Message{MsgType: WhMsgTypeText, Text: m.Text.Content, At: m.At, rawText: true}, nil
+	case WhTextMsg:
+		return NewMessageFromWhMsg(&m)
+	case *WhMarkdownMsg:
+		return &Message{MsgType: WhMsgTypeMarkdown, Title: m.MarkDown.Title, Text: m.MarkDown.Text, At: m.At, rawText: true}, nil
+	case WhMarkdownMsg:
+		return NewMessageFromWhMsg(&m)
+	case *WhLinkMsg:
+		return NewLinkMessage(m.Link.Title, m.Link.Text, m.Link.PicUrl, m.Link.MessageUrl), nil
+	case WhLinkMsg:
+		return NewMessageFromWhMsg(&m)
+	case *WhEntiretyActionCardMsg:
+		a := m.ActionCard
+		return NewActionCardMessage(a.Title, a.Text, a.SingleTitle, a.SingleURL), nil
+	case WhEntiretyActionCardMsg:
+		return NewMessageFromWhMsg(&m)
+	case *WhIndependentActionCardMsg:
+		a := m.ActionCard
+		return NewIndependentActionCardMessage(a.Title, a.Text, a.BtnOrientation, a.Btns), nil
+	case WhIndependentActionCardMsg:
+		return NewMessageFromWhMsg(&m)
+	case *WhFeedCardMsg:
+		return NewFeedCardMessage(m.FeedCard.Links), nil
+	case WhFeedCardMsg:
+		return NewMessageFromWhMsg(&m)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedMsgType, msg)
+}
+
+// Send 通过webhook方式发送通用消息，经过客户端的中间件、钩子和重试
+func (c *WhClient) Send(msg *Message) error {
+	return c.send("webhook", c, msg, func(msg *Message) error {
+		whMsg, err := msg.WhMsg()
+		if err != nil {
+			return err
+		}
+		return c.sendDingWebhookMsg(c.GetUrl(), whMsg)
+	})
+}
+
+// String webhook客户端的名字，access_token只保留前6位
+func (c *WhClient) String() string {
+	if c.SessionWebhookUrl != "" {
+		return "webhook:session"
+	}
+	token := c.AccessToken
+	if len(token) > 6 {
+		token = token[:6] + "..."
+	}
+	return "webhook:" + token
+}
+
+// TargetKey 由完整的webhook地址和access_token得到，每个会话的sessionWebhook都不同
+func (c *WhClient) TargetKey() string {
+	if c.SessionWebhookUrl != "" {
+		return hashTargetKey("webhook", c.SessionWebhookUrl)
+	}
+	return hashTargetKey("webhook", c.BaseUrl, c.AccessToken)
+}
+
+// GroupSender 接口方式发送到openConversationId这个群的Sender
+type GroupSender struct {
+	Client             *GroupClient
+	OpenConversationId string
+}
+
+// Sender 返回发送到openConversationId这个群的Sender
+func (g *GroupClient) Sender(openConversationId string) *GroupSender {
+	return &GroupSender{Client: g, OpenConversationId: openConversationId}
+}
+
+// Send 发送通用消息到群，经过客户端的中间件、钩子和重试
+func (s *GroupSender) Send(msg *Message) error {
+	g := s.Client
+	return g.send("group", s, msg, func(msg *Message) error {
+		msgKey, msgParam, err := msg.InterfaceMsg()
+		if err != nil {
+			return err
+		}
+		return g.sendDingInterfaceMsg(g.url, g.createGroupMessageBody(s.OpenConversationId, msgKey, msgParam))
+	})
+}
+
+func (s *GroupSender) String() string {
+	return "group:" + s.OpenConversationId
+}
+
+// TargetKey 由发送的地址、robotCode和群id得到
+func (s *GroupSender) TargetKey() string {
+	return hashTargetKey("group", s.Client.url, s.Client.RobotCode, s.OpenConversationId)
+}
+
+// OtOSender 接口方式发送单聊给userIds这些用户的Sender
+type OtOSender struct {
+	Client  *OtOClient
+	UserIds []string
+}
+
+// Sender 返回发送单聊给userIds这些用户的Sender
+func (o *OtOClient) Sender(userIds ...string) *OtOSender {
+	return &OtOSender{Client: o, UserIds: userIds}
+}
+
+// Send 发送通用消息给用户，经过客户端的中间件、钩子和重试
+func (s *OtOSender) Send(msg *Message) error {
+	o := s.Client
+	return o.send("oto", s, msg, func(msg *Message) error {
+		msgKey, msgParam, err := msg.InterfaceMsg()
+		if err != nil {
+			return err
+		}
+		return o.sendDingInterfaceMsg(o.url, o.createOtOMessageBody(msgKey, msgParam, s.UserIds))
+	})
+}
+
+func (s *OtOSender) String() string {
+	return "oto:" + strings.Join(s.UserIds, ",")
+}
+
+// TargetKey 由发送的地址、robotCode和用户得到
+func (s *OtOSender) TargetKey() string {
+	return hashTargetKey("oto", s.Client.url, s.Client.RobotCode, strings.Join(s.UserIds, ","))
+}
+
+// Sender 返回注册表中这个目标的Sender
+func (t *Target) Sender() Sender {
+	switch {
+	case t.Webhook != nil:
+		return t.Webhook
+	case t.Group != nil:
+		return t.Group.Sender(t.OpenConversationId)
+	case t.OtO != nil:
+		return t.OtO.Sender(t.UserIds...)
+	}
+	return nil
+}
+
+// Send 发送通用消息到注册表中的这个目标
+func (t *Target) Send(msg *Message) error {
+	s := t.Sender()
+	if s == nil {
+		return fmt.Errorf("ding: target %q has no client", t.Name)
+	}
+	return s.Send(msg)
+}
+
+func (t *Target) String() string {
+	return t.Name
+}
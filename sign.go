@@ -4,6 +4,8 @@ import (
     "crypto/hmac"
     "crypto/sha256"
     "encoding/base64"
+    "strconv"
+    "time"
 )
 
 // GetSign 获取前面， 传入数据data，和密钥secret
@@ -18,3 +20,22 @@ func GetDingSign(timestamp, secret string) string {
     data := timestamp + "\n" + secret
     return GetSign(data, secret)
 }
+
+// CheckDingSign 校验钉钉回调请求的timestamp和sign，timestamp为毫秒，和当前时间相差超过maxSkew时不通过，maxSkew<=0时不校验时间
+// 参考： https://open.dingtalk.com/document/orgapp/receive-message
+func CheckDingSign(timestamp, sign, secret string, maxSkew time.Duration) bool {
+    if timestamp == "" || sign == "" {
+        return false
+    }
+    ms, err := strconv.ParseInt(timestamp, 10, 64)
+    if err != nil {
+        return false
+    }
+    if maxSkew > 0 {
+        skew := time.Since(time.UnixMilli(ms))
+        if skew > maxSkew || skew < -maxSkew {
+            return false
+        }
+    }
+    return hmac.Equal([]byte(GetDingSign(timestamp, secret)), []byte(sign))
+}
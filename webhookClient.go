@@ -6,17 +6,17 @@ package ding
 // 参考： https://github.com/wanghuiyt/ding/blob/main/ding.go
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
 var (
 	ContentTypeJson = "application/json; charset=utf-8"
+	// WebhookBaseUrl 默认的webhook地址，不含access_token
+	WebhookBaseUrl = "https://oapi.dingtalk.com/robot/send"
 )
 
 // WhClient 钉钉webhook客户端, webhook方式只支持在群聊会话。
@@ -31,6 +31,30 @@ type WhClient struct {
 	// 钉钉安全设置，关键字
 	// todo 还未实现
 	KeyWorld string
+	// webhook地址，不含access_token，为空时使用 WebhookBaseUrl。用于代理或测试
+	BaseUrl string
+	// 发送中间件、钩子和重试
+	Pipeline
+
+	transport
+}
+
+// NewWhClientWithOptions 创建钉钉webhook客户端，secret为加签密钥，没有开启加签时传空字符串
+func NewWhClientWithOptions(accessToken, secret string, opts ...Option) (*WhClient, error) {
+	if accessToken == "" {
+		return nil, fmt.Errorf("%w: access token is required", ErrInvalidClientConfig)
+	}
+	c := NewWhClientWithSecret(accessToken, secret)
+	c.applyOptions(newClientOptions(opts))
+	return c, nil
+}
+
+func (c *WhClient) applyOptions(o *clientOptions) {
+	c.setOptions(o)
+	o.installPipeline(&c.Pipeline)
+	if o.endpoint != "" {
+		c.BaseUrl = o.endpoint
+	}
 }
 
 // NewWhClientWithoutSecret 创建钉钉客户端，不用密钥
@@ -49,8 +73,10 @@ func NewWhClientWithSecret(aToken, secret string) *WhClient {
 }
 
 // NewWhClientUseSessionWebhook 通过企业内部机器人postReq 发来的SessionWebhook地址来发送消息
-func NewWhClientUseSessionWebhook(sessionWebhookUrl string) *WhClient {
-	return &WhClient{SessionWebhookUrl: sessionWebhookUrl}
+func NewWhClientUseSessionWebhook(sessionWebhookUrl string, opts ...Option) *WhClient {
+	c := &WhClient{SessionWebhookUrl: sessionWebhookUrl}
+	c.applyOptions(newClientOptions(opts))
+	return c
 }
 
 // GetUrl 获取 给钉钉发送post的url， 根据是否有安全设置会有不同的url
@@ -58,110 +84,104 @@ func (c *WhClient) GetUrl() string {
 	if c.SessionWebhookUrl != "" {
 		return c.SessionWebhookUrl
 	} else {
-		wh := "https://oapi.dingtalk.com/robot/send?access_token=" + c.AccessToken
+		base := c.BaseUrl
+		if base == "" {
+			base = WebhookBaseUrl
+		}
+		sep := "?"
+		if strings.Contains(base, "?") {
+			sep = "&"
+		}
+		wh := base + sep + "access_token=" + url.QueryEscape(c.AccessToken)
 		if len(c.Secret) > 0 {
-			timestame := time.Now().Unix()
-			data := fmt.Sprintf("%d\n%s", timestame, c.Secret)
+			// 钉钉要求timestamp单位为毫秒，之前用的Unix()是秒，和钉钉文档不一致；sign是base64，含有+和/，需要url编码
+			timestamp := time.Now().UnixMilli()
+			data := fmt.Sprintf("%d\n%s", timestamp, c.Secret)
 			sign := GetSign(data, c.Secret)
-			return fmt.Sprintf("%s&timestamp=%d&sign=%s", wh, timestame, sign)
+			return fmt.Sprintf("%s&timestamp=%d&sign=%s", wh, timestamp, url.QueryEscape(sign))
 		}
 		return wh
 	}
 }
 
 // sendDingWebhookMsg 发送钉钉webhook post 请求，即发送消息。msg为message.go里定义的
-func sendDingWebhookMsg(url string, msg any) error {
-	b, err := json.Marshal(msg)
+func (c *WhClient) sendDingWebhookMsg(url string, msg any) error {
+	statusCode, respByte, err := c.postJson(c.client(http.DefaultClient), url, nil, msg)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(url, ContentTypeJson, bytes.NewBuffer(b))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	c.debugf("发送钉钉webhook消息后，收到钉钉的回复: %v\n", string(respByte))
+	return parseDingResp(statusCode, respByte)
+}
 
-	respByte, err := io.ReadAll(resp.Body)
+// SendWhMsg 发送任意webhook消息，msg为message.go里定义的Wh*Msg（指针或值），适用于需要自行组装消息（如同时@userIds和@mobile）的场景。
+// 消息内容原样发送，经过客户端的中间件、钩子和重试
+func (c *WhClient) SendWhMsg(msg any) error {
+	m, err := NewMessageFromWhMsg(msg)
 	if err != nil {
 		return err
 	}
-	if Debug {
-		log.Printf("发送钉钉webhook消息后，收到钉钉的回复: %v\n", string(respByte))
-	}
-	return nil
+	return c.Send(m)
 }
 
 // SendTextMsgWithUserIds 发送文本消息，群聊, @userIds
 func (c *WhClient) SendTextMsgWithUserIds(content string, userIds []string) error {
-	msg := NewWhTextMsgWithAtUserIds(content, userIds...)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhTextMsgWithAtUserIds(content, userIds...))
 }
 
 // SendTextMsgWithUserMobile 发送文本消息，群聊, @mobile
 func (c *WhClient) SendTextMsgWithUserMobile(content string, mobiles []string) error {
-	msg := NewWhTextMsgWithAtMobiles(content, mobiles...)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhTextMsgWithAtMobiles(content, mobiles...))
 }
 
 // SendTextMsgWithAtAll 发送文本消息，群聊, @all
 func (c *WhClient) SendTextMsgWithAtAll(content string) error {
-	msg := NewWhTextMsgWithAtAll(content)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhTextMsgWithAtAll(content))
 }
 
 // SendTextMsg 发送文本消息，群聊
 func (c *WhClient) SendTextMsg(content string) error {
-	msg := NewWhTextMsg(content)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhTextMsg(content))
 }
 
 // SendMarkdownMsgWithUserIds 发送markdown消息，群聊，@userIds
 func (c *WhClient) SendMarkdownMsgWithUserIds(title, text string, userIds []string) error {
-	msg := NewWhMarkdownMsgWithAtUserIds(title, text, userIds...)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhMarkdownMsgWithAtUserIds(title, text, userIds...))
 }
 
 // SendMarkdownMsgWithUserMobile 发送markdown消息，群聊，@mobile
 func (c *WhClient) SendMarkdownMsgWithUserMobile(title, text string, mobiles []string) error {
-	msg := NewWhMarkdownMsgWithAtMobiles(title, text, mobiles...)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhMarkdownMsgWithAtMobiles(title, text, mobiles...))
 }
 
 // SendMarkdownMsgWithAtAll 发送markdown消息，群聊，@all
 func (c *WhClient) SendMarkdownMsgWithAtAll(title, text string) error {
-	msg := NewWhMarkdownMsgWithAtAll(title, text)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhMarkdownMsgWithAtAll(title, text))
 }
 
 // SendMarkdownMsg 发送markdown消息，群聊
 func (c *WhClient) SendMarkdownMsg(title, text string) error {
-	msg := NewWhMarkdownMsg(title, text)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhMarkdownMsg(title, text))
 }
 
 // SendLinkMsg 发送link链接消息，群聊，这个不能@某人
 func (c *WhClient) SendLinkMsg(title, text, messageUrl, picUrl string) error {
-	msg := NewWhLinkMsg(text, title, picUrl, messageUrl)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhLinkMsg(text, title, picUrl, messageUrl))
 }
 
 // SendEntiretyActionCardMsg 发送整体跳转actionCard 消息
 func (c *WhClient) SendEntiretyActionCardMsg(title, text, singleTitle, singleURL string) error {
-	msg := NewWhEntiretyActionCardMsg(title, text, singleTitle, singleURL)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhEntiretyActionCardMsg(title, text, singleTitle, singleURL))
 }
 
 func (c *WhClient) SendIndependentActionCardMsg(title, text string, btns []*Btn) error {
-	msg := NewWhIndependentActionCardMsg(title, text, btns)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhIndependentActionCardMsg(title, text, btns))
 }
 
 func (c *WhClient) SendIndependentActionCardMsgWithBtnOrientation(title, text, btnOrientation string, btns []*Btn) error {
-	msg := NewWhIndependentActionCardMsgWithBtnOrientation(title, text, btnOrientation, btns)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhIndependentActionCardMsgWithBtnOrientation(title, text, btnOrientation, btns))
 }
 
 func (c *WhClient) SendWhFeedCardMsg(links []*Link) error {
-	msg := NewWhFeedCardMsg(links)
-	return sendDingWebhookMsg(c.GetUrl(), msg)
+	return c.SendWhMsg(NewWhFeedCardMsg(links))
 }
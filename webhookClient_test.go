@@ -0,0 +1,91 @@
+package ding_test
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wanghkkk/ding"
+	"github.com/wanghkkk/ding/dingtest"
+)
+
+func TestWhClientGetUrlSign(t *testing.T) {
+	// 这个secret的签名含有+和/，需要url编码
+	const secret = "SEC+/secret"
+	c := &ding.WhClient{AccessToken: "token", Secret: secret, BaseUrl: "https://example.com/robot/send"}
+	before := time.Now()
+	u, err := url.Parse(c.GetUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	ts, err := strconv.ParseInt(q.Get("timestamp"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// timestamp为毫秒
+	if sent := time.UnixMilli(ts); sent.Before(before.Truncate(time.Millisecond)) || sent.After(time.Now()) {
+		t.Errorf("timestamp %d is not the current unix milliseconds", ts)
+	}
+	if !ding.CheckDingSign(q.Get("timestamp"), q.Get("sign"), secret, time.Minute) {
+		t.Errorf("sign %q does not verify", q.Get("sign"))
+	}
+	if q.Get("access_token") != "token" {
+		t.Errorf("access_token = %q", q.Get("access_token"))
+	}
+
+	c.Secret = ""
+	if got, want := c.GetUrl(), "https://example.com/robot/send?access_token=token"; got != want {
+		t.Errorf("GetUrl without secret = %q, want %q", got, want)
+	}
+}
+
+func TestWhClientSignVerifiedByServer(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("signed", "SEC+/secret")
+
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{name: "right secret", secret: "SEC+/secret"},
+		{name: "wrong secret", secret: "SEC-other", wantErr: true},
+		{name: "no secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv.Reset()
+			c, err := srv.NewWhClient("signed", tt.secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Send(ding.NewTextMessage("hello"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if n := len(srv.Messages()); tt.wantErr && n != 0 || !tt.wantErr && n != 1 {
+				t.Errorf("delivered %d messages", n)
+			}
+		})
+	}
+}
+
+func TestServerRejectsSecondTimestamp(t *testing.T) {
+	srv := dingtest.NewServer()
+	defer srv.Close()
+	srv.AddRobot("signed", "secret")
+
+	// 秒为单位的timestamp相当于1970年，和当前时间相差太多，钉钉会拒绝
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	u := srv.WebhookUrl() + "?access_token=signed&timestamp=" + ts + "&sign=" + url.QueryEscape(ding.GetDingSign(ts, "secret"))
+	c := ding.NewWhClientUseSessionWebhook(u)
+	if err := c.Send(ding.NewTextMessage("hello")); err == nil {
+		t.Error("a timestamp in seconds passed the sign check")
+	}
+	if n := len(srv.Messages()); n != 0 {
+		t.Errorf("delivered %d messages, want 0", n)
+	}
+}
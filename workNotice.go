@@ -0,0 +1,387 @@
+// 工作通知，以企业内部应用的身份给任意员工、部门或全员发送消息，不需要员工和机器人聊过天
+// 参考： https://open.dingtalk.com/document/orgapp/asynchronous-sending-of-enterprise-session-messages
+// https://open.dingtalk.com/document/orgapp/obtain-the-sending-progress-of-asynchronous-sending-of-enterprise-session-messages
+// https://open.dingtalk.com/document/orgapp/notification-of-work-withdrawal
+
+package ding
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 工作通知接口的路径，地址为客户端的旧版接口地址加上路径，见 WithBaseUrl
+const (
+	// WorkNoticeSendPath 异步发送工作通知
+	WorkNoticeSendPath = "/topapi/message/corpconversation/asyncsend_v2"
+	// WorkNoticeProgressPath 查询工作通知发送进度
+	WorkNoticeProgressPath = "/topapi/message/corpconversation/getsendprogress"
+	// WorkNoticeResultPath 查询工作通知发送结果
+	WorkNoticeResultPath = "/topapi/message/corpconversation/getsendresult"
+	// WorkNoticeRecallPath 撤回工作通知
+	WorkNoticeRecallPath = "/topapi/message/corpconversation/recall"
+)
+
+var (
+	// WorkNoticeMsgTypeOA OA消息，只有工作通知支持
+	WorkNoticeMsgTypeOA = "oa"
+	// MaxWorkNoticeUsers 一次最多发送给多少个用户
+	MaxWorkNoticeUsers = 100
+	// MaxWorkNoticeDepts 一次最多发送给多少个部门
+	MaxWorkNoticeDepts = 20
+
+	// ErrInvalidRecipients 接收者不合法，如没有接收者或超过数量上限
+	ErrInvalidRecipients = errors.New("ding: invalid recipients")
+)
+
+// 工作通知发送进度的状态
+const (
+	WorkNoticeStatusNotStarted = 0
+	WorkNoticeStatusProcessing = 1
+	WorkNoticeStatusDone       = 2
+)
+
+// WorkNoticeClient 工作通知客户端
+type WorkNoticeClient struct {
+	*IClient
+	// 企业内部应用的AgentId
+	AgentId int64
+}
+
+// NewWorkNoticeClient 创建工作通知客户端，agentId为企业内部应用的AgentId
+func NewWorkNoticeClient(agentId int64, appKey, appSecret string, opts ...Option) (*WorkNoticeClient, error) {
+	if agentId == 0 {
+		return nil, fmt.Errorf("%w: agentId is required", ErrInvalidClientConfig)
+	}
+	c, err := newIClientWithOptions("", "", appKey, appSecret, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkNoticeClient{IClient: c, AgentId: agentId}, nil
+}
+
+// WorkNoticeTo 工作通知的接收者，UserIds、DeptIds、ToAllUser 至少有一个
+type WorkNoticeTo struct {
+	// 接收者的userId，最多 MaxWorkNoticeUsers 个
+	UserIds []string
+	// 接收者的部门id，最多 MaxWorkNoticeDepts 个
+	DeptIds []int64
+	// 是否发送给企业全部用户
+	ToAllUser bool
+}
+
+func (t *WorkNoticeTo) String() string {
+	switch {
+	case t.ToAllUser:
+		return "all"
+	case len(t.DeptIds) > 0:
+		ids := make([]string, len(t.DeptIds))
+		for i, id := range t.DeptIds {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		return strings.Join(append(ids, t.UserIds...), ",")
+	default:
+		return strings.Join(t.UserIds, ",")
+	}
+}
+
+func (t *WorkNoticeTo) validate() error {
+	switch {
+	case t == nil || !t.ToAllUser && len(t.UserIds) == 0 && len(t.DeptIds) == 0:
+		return fmt.Errorf("%w: work notice has no recipients", ErrInvalidRecipients)
+	case len(t.UserIds) > MaxWorkNoticeUsers:
+		return fmt.Errorf("%w: at most %d users per work notice, got %d", ErrInvalidRecipients, MaxWorkNoticeUsers, len(t.UserIds))
+	case len(t.DeptIds) > MaxWorkNoticeDepts:
+		return fmt.Errorf("%w: at most %d departments per work notice, got %d", ErrInvalidRecipients, MaxWorkNoticeDepts, len(t.DeptIds))
+	}
+	return nil
+}
+
+// WorkNoticeMsg 工作通知消息，和webhook消息的格式不同
+type WorkNoticeMsg struct {
+	MsgType    string                `json:"msgtype"`
+	Text       *Text                 `json:"text,omitempty"`
+	Markdown   *Markdown             `json:"markdown,omitempty"`
+	Link       *Link                 `json:"link,omitempty"`
+	ActionCard *WorkNoticeActionCard `json:"action_card,omitempty"`
+	OA         *OA                   `json:"oa,omitempty"`
+}
+
+// WorkNoticeActionCard 工作通知的卡片消息，SingleTitle 和 Btns 二选一
+type WorkNoticeActionCard struct {
+	Title          string           `json:"title"`
+	Markdown       string           `json:"markdown"`
+	SingleTitle    string           `json:"single_title,omitempty"`
+	SingleURL      string           `json:"single_url,omitempty"`
+	BtnOrientation string           `json:"btn_orientation,omitempty"`
+	Btns           []*WorkNoticeBtn `json:"btn_json_list,omitempty"`
+}
+
+// WorkNoticeBtn 工作通知卡片消息的按钮
+type WorkNoticeBtn struct {
+	Title     string `json:"title"`
+	ActionURL string `json:"action_url"`
+}
+
+// OA OA消息，用于审批、待办等提醒
+type OA struct {
+	// 点击消息跳转的URL
+	MessageUrl string `json:"message_url"`
+	// PC端点击消息跳转的URL，为空时使用 MessageUrl
+	PcMessageUrl string `json:"pc_message_url,omitempty"`
+	// 消息头部
+	Head OAHead `json:"head"`
+	// 消息体
+	Body OABody `json:"body"`
+	// 消息状态栏，可选
+	StatusBar *OAStatusBar `json:"status_bar,omitempty"`
+}
+
+// OAHead OA消息的头部
+type OAHead struct {
+	// 头部背景色，ARGB格式，如 FFBBBBBB
+	Bgcolor string `json:"bgcolor"`
+	// 头部标题
+	Text string `json:"text"`
+}
+
+// OABody OA消息体
+type OABody struct {
+	Title     string    `json:"title,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	FileCount string    `json:"file_count,omitempty"`
+	Form      []*OAForm `json:"form,omitempty"`
+	Rich      *OARich   `json:"rich,omitempty"`
+}
+
+// OAForm OA消息体中的一行键值
+type OAForm struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// OARich OA消息体中的单行富文本，如金额
+type OARich struct {
+	Num  string `json:"num"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// OAStatusBar OA消息的状态栏
+type OAStatusBar struct {
+	StatusValue string `json:"status_value"`
+	StatusBg    string `json:"status_bg,omitempty"`
+}
+
+// NewOA 创建OA消息，headText为头部标题，title为消息体标题，messageUrl为点击跳转的URL
+func NewOA(headText, title, messageUrl string) *OA {
+	return &OA{
+		MessageUrl: messageUrl,
+		Head:       OAHead{Bgcolor: "FFBBBBBB", Text: headText},
+		Body:       OABody{Title: title},
+	}
+}
+
+// AddForm 添加一行键值
+func (o *OA) AddForm(key, value string) *OA {
+	o.Body.Form = append(o.Body.Form, &OAForm{Key: key, Value: value})
+	return o
+}
+
+// WithContent 设置消息体的内容
+func (o *OA) WithContent(content string) *OA {
+	o.Body.Content = content
+	return o
+}
+
+// WithStatus 设置状态栏，如 待审批
+func (o *OA) WithStatus(value, bg string) *OA {
+	o.StatusBar = &OAStatusBar{StatusValue: value, StatusBg: bg}
+	return o
+}
+
+// NewWorkNoticeOAMsg OA工作通知
+func NewWorkNoticeOAMsg(oa *OA) *WorkNoticeMsg {
+	return &WorkNoticeMsg{MsgType: WorkNoticeMsgTypeOA, OA: oa}
+}
+
+// NewWorkNoticeMsg 把通用消息转换成工作通知消息，支持text、markdown、link和actionCard，不支持@
+func NewWorkNoticeMsg(m *Message) (*WorkNoticeMsg, error) {
+	switch m.MsgType {
+	case WhMsgTypeText:
+		return &WorkNoticeMsg{MsgType: m.MsgType, Text: &Text{Content: m.Text}}, nil
+	case WhMsgTypeMarkdown:
+		return &WorkNoticeMsg{MsgType: m.MsgType, Markdown: &Markdown{Title: m.Title, Text: m.Text}}, nil
+	case WhMsgTypeLink:
+		return &WorkNoticeMsg{MsgType: m.MsgType, Link: NewLink(m.Title, m.Text, m.PicUrl, m.MessageUrl)}, nil
+	case WhMsgTypeActionCard:
+		ac := &WorkNoticeActionCard{
+			Title:          m.Title,
+			Markdown:       m.Text,
+			SingleTitle:    m.SingleTitle,
+			SingleURL:      m.SingleURL,
+			BtnOrientation: m.BtnOrientation,
+		}
+		for _, b := range m.Btns {
+			ac.Btns = append(ac.Btns, &WorkNoticeBtn{Title: b.Title, ActionURL: b.ActionURL})
+		}
+		return &WorkNoticeMsg{MsgType: "action_card", ActionCard: ac}, nil
+	}
+	return nil, fmt.Errorf("%w: %q in work notice", ErrUnsupportedMsgType, m.MsgType)
+}
+
+// SendMsg 发送工作通知，返回任务id，用于查询进度、结果和撤回。不经过客户端的中间件、钩子和重试
+func (c *WorkNoticeClient) SendMsg(to *WorkNoticeTo, msg *WorkNoticeMsg) (int64, error) {
+	if err := to.validate(); err != nil {
+		return 0, err
+	}
+	req := map[string]any{
+		"agent_id": c.AgentId,
+		"msg":      msg,
+	}
+	if len(to.UserIds) > 0 {
+		req["userid_list"] = strings.Join(to.UserIds, ",")
+	}
+	if len(to.DeptIds) > 0 {
+		ids := make([]string, len(to.DeptIds))
+		for i, id := range to.DeptIds {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		req["dept_id_list"] = strings.Join(ids, ",")
+	}
+	if to.ToAllUser {
+		req["to_all_user"] = true
+	}
+	var resp struct {
+		TaskId int64 `json:"task_id"`
+	}
+	if err := c.callOapiRaw(c.oapiUrl(WorkNoticeSendPath), req, &resp); err != nil {
+		return 0, err
+	}
+	return resp.TaskId, nil
+}
+
+// Send 发送通用消息，返回任务id
+func (c *WorkNoticeClient) Send(to *WorkNoticeTo, m *Message) (int64, error) {
+	msg, err := NewWorkNoticeMsg(m)
+	if err != nil {
+		return 0, err
+	}
+	return c.SendMsg(to, msg)
+}
+
+// SendTextMsg 发送文本工作通知
+func (c *WorkNoticeClient) SendTextMsg(content string, to *WorkNoticeTo) (int64, error) {
+	return c.Send(to, NewTextMessage(content))
+}
+
+// SendMarkdownMsg 发送markdown工作通知
+func (c *WorkNoticeClient) SendMarkdownMsg(title, text string, to *WorkNoticeTo) (int64, error) {
+	return c.Send(to, NewMarkdownMessage(title, text))
+}
+
+// SendLinkMsg 发送链接工作通知
+func (c *WorkNoticeClient) SendLinkMsg(title, text, picUrl, messageUrl string, to *WorkNoticeTo) (int64, error) {
+	return c.Send(to, NewLinkMessage(title, text, picUrl, messageUrl))
+}
+
+// SendActionCardMsg 发送整体跳转的卡片工作通知
+func (c *WorkNoticeClient) SendActionCardMsg(title, text, singleTitle, singleURL string, to *WorkNoticeTo) (int64, error) {
+	return c.Send(to, NewActionCardMessage(title, text, singleTitle, singleURL))
+}
+
+// SendOAMsg 发送OA工作通知
+func (c *WorkNoticeClient) SendOAMsg(oa *OA, to *WorkNoticeTo) (int64, error) {
+	return c.SendMsg(to, NewWorkNoticeOAMsg(oa))
+}
+
+// WorkNoticeProgress 工作通知的发送进度
+type WorkNoticeProgress struct {
+	// 发送进度百分比
+	ProgressInPercent int `json:"progress_in_percent"`
+	// 状态：WorkNoticeStatusNotStarted、WorkNoticeStatusProcessing、WorkNoticeStatusDone
+	Status int `json:"status"`
+}
+
+// Progress 查询工作通知的发送进度
+func (c *WorkNoticeClient) Progress(taskId int64) (*WorkNoticeProgress, error) {
+	var resp struct {
+		Progress WorkNoticeProgress `json:"progress"`
+	}
+	req := map[string]int64{"agent_id": c.AgentId, "task_id": taskId}
+	if err := c.callOapiRaw(c.oapiUrl(WorkNoticeProgressPath), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Progress, nil
+}
+
+// WorkNoticeResult 工作通知的发送结果
+type WorkNoticeResult struct {
+	// 无效的userId
+	InvalidUserIds []string `json:"invalid_user_id_list"`
+	// 因发送消息过于频繁或超量被流控的userId
+	ForbiddenUserIds []string `json:"forbidden_user_id_list"`
+	// 发送失败的userId
+	FailedUserIds []string `json:"failed_user_id_list"`
+	// 已读的userId
+	ReadUserIds []string `json:"read_user_id_list"`
+	// 未读的userId
+	UnreadUserIds []string `json:"unread_user_id_list"`
+	// 无效的部门id
+	InvalidDeptIds []int64 `json:"invalid_dept_id_list"`
+}
+
+// Result 查询工作通知的发送结果
+func (c *WorkNoticeClient) Result(taskId int64) (*WorkNoticeResult, error) {
+	var resp struct {
+		SendResult WorkNoticeResult `json:"send_result"`
+	}
+	req := map[string]int64{"agent_id": c.AgentId, "task_id": taskId}
+	if err := c.callOapiRaw(c.oapiUrl(WorkNoticeResultPath), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.SendResult, nil
+}
+
+// Recall 撤回工作通知，只能撤回24小时内发送的
+func (c *WorkNoticeClient) Recall(taskId int64) error {
+	req := map[string]int64{"agent_id": c.AgentId, "msg_task_id": taskId}
+	return c.callOapiRaw(c.oapiUrl(WorkNoticeRecallPath), req, nil)
+}
+
+// WorkNoticeSender 发送工作通知给To的Sender
+type WorkNoticeSender struct {
+	Client *WorkNoticeClient
+	To     *WorkNoticeTo
+}
+
+// Sender 返回发送工作通知给to的Sender，经过客户端的中间件、钩子和重试，任务id不返回
+func (c *WorkNoticeClient) Sender(to *WorkNoticeTo) *WorkNoticeSender {
+	return &WorkNoticeSender{Client: c, To: to}
+}
+
+// Send 发送通用消息，实现 Sender
+func (s *WorkNoticeSender) Send(msg *Message) error {
+	c := s.Client
+	return c.send("worknotice", s, msg, func(msg *Message) error {
+		_, err := c.Send(s.To, msg)
+		return err
+	})
+}
+
+func (s *WorkNoticeSender) String() string {
+	return "worknotice:" + s.To.String()
+}
+
+// TargetKey 由应用和接收者得到
+func (s *WorkNoticeSender) TargetKey() string {
+	depts := make([]string, len(s.To.DeptIds))
+	for i, id := range s.To.DeptIds {
+		depts[i] = strconv.FormatInt(id, 10)
+	}
+	return hashTargetKey("worknotice", s.Client.AppKey, strconv.FormatInt(s.Client.AgentId, 10),
+		strconv.FormatBool(s.To.ToAllUser), strings.Join(depts, ","), strings.Join(s.To.UserIds, ","))
+}